@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"           // to restore request bodies
 	"crypto/hmac"     // for authentication verification
 	"crypto/md5"      // for authentication verification
 	"encoding/base64" // for authentication verification
@@ -19,26 +20,29 @@ func IsAuthenticated(ctx *WebContext) bool {
 
 	// ensure the header was provided
 	if authHeader == "" {
-		error = MessageError{401, "You must authenticate prior to accessing this resource."}
+		error = MessageError{Code: 401, Message: "You must authenticate prior to accessing this resource."}
 	} else {
 		// parse header to ensure GDS key:value
 		authFields := strings.Fields(authHeader)
 		if len(authFields) != 2 {
-			error = MessageError{401, "The Authenticate header must be of the form 'GDS username:signature'."}
+			error = MessageError{Code: 401, Message: "The Authenticate header must be of the form 'GDS username:signature'."}
 		} else {
 			// ensure appropriate auth method
 			if authFields[0] != "GDS" {
-				error = MessageError{401, "The Authenticate header must be of the form 'GDS username:signature'."}
+				error = MessageError{Code: 401, Message: "The Authenticate header must be of the form 'GDS username:signature'."}
 			} else {
 				// parse key:value
 				keyValue := strings.Split(authFields[1], ":")
 				if len(keyValue) != 2 {
-					error = MessageError{401, "The Authenticate header must be of the form 'GDS username:signature'."}
+					error = MessageError{Code: 401, Message: "The Authenticate header must be of the form 'GDS username:signature'."}
 				} else {
 					// ensure key exists and is valid user
-					// for now, we use a static username and password
-					if keyValue[0] != "username" {
-						error = MessageError{401, "The Authenticate header did not contain a valid user."}
+					user, err := LoadUserByUsername(DbConnect(), keyValue[0])
+					if err != nil && err != ErrNotFound {
+						ctx.ServerError(err, "Your credentials could not be checked.")
+						return false
+					} else if err != nil {
+						error = MessageError{Code: 401, Message: "The Authenticate header did not contain a valid user."}
 					} else {
 						// validate value is as expected
 						var (
@@ -49,6 +53,9 @@ func IsAuthenticated(ctx *WebContext) bool {
 
 						body, _ := ioutil.ReadAll(ctx.Request.Body)
 
+						// put the body back so the handler can still read it
+						ctx.Request.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
 						// do an MD5 hash of the body
 						bodyHash = md5.New()
 						bodyHash.Write(body)
@@ -60,15 +67,16 @@ func IsAuthenticated(ctx *WebContext) bool {
 						signature += ctx.Request.URL.Path + "\n"
 
 						// create the hmac
-						sigHmac = hmac.NewSHA1([]byte("password"))
+						sigHmac = hmac.NewSHA1([]byte(user.Secret))
 						sigHmac.Write([]byte(signature))
 
 						correctHash := base64.StdEncoding.EncodeToString(sigHmac.Sum(nil))
 
 						if keyValue[1] != correctHash {
-							error = MessageError{401, "The Authenticate header did not contain a valid signature."}
+							error = MessageError{Code: 401, Message: "The Authenticate header did not contain a valid signature."}
 						} else {
 							// TODO validate date is current to within 15min
+							ctx.User = user
 						}
 					}
 				}
@@ -78,11 +86,17 @@ func IsAuthenticated(ctx *WebContext) bool {
 
 	if error.Code != 0 {
 		ctx.Header.Set("WWW-Authenticate", "GDS realm=\"http://api.citeplasm.com/\"")
-		ctx.Abort(401, error.Json())
+		ctx.Error(error.Code, error.Message)
 		return false
 	}
 
 	return true
 }
 
-
+// RequireAuth is Middleware that only lets requests through to the handler if
+// IsAuthenticated accepts them.
+func RequireAuth(ctx *WebContext, next func()) {
+	if IsAuthenticated(ctx) {
+		next()
+	}
+}
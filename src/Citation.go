@@ -0,0 +1,210 @@
+package main
+
+import (
+    "godis"
+    "net/url"       // for validating URLs
+    "strconv"
+)
+
+// Citation represents a resource held by a User, such as a book or an
+// article, as supplied by a Provider. It is served under
+// /users/id/resources; it is not to be confused with Resource, which is the
+// generic reference to any object represented by the API.
+type Citation struct {
+
+    // Identifier is the unique ID of the citation.
+    Identifier string `json:"-" xml:"-" db:"-"`
+
+    // Owner is the ID of the User who holds the citation.
+    Owner string `json:"owner" xml:"owner"`
+
+    // Title is the title of the cited work.
+    Title string `json:"title" xml:"title" search:"3"`
+
+    // Authors are the names of the authors of the cited work.
+    Authors []string `json:"authors" xml:"authors>author" db:",omitempty" search:"2"`
+
+    // Url is the address at which the cited work can be found.
+    Url string `json:"url" xml:"url" db:",omitempty"`
+
+    // Identifiers are standard identifiers of the cited work, written as
+    // "scheme:value" (e.g. "isbn:0262510871" or "doi:10.1000/182").
+    Identifiers []string `json:"identifiers" xml:"identifiers>identifier" db:",omitempty"`
+
+    // Provider is the ID of the Provider that supplied the cited work.
+    Provider string `json:"provider" xml:"provider"`
+
+    // db is an internal pointer to the database connection.
+    db *godis.Client `json:"-" xml:"-"`
+}
+
+// init registers Citations as a Model.
+func init() {
+    RegisterModel("rsrc:", func(db *godis.Client, id string) (DbObject, error) {
+        c, err := LoadCitation(db, id)
+        if err != nil {
+            return nil, err
+        }
+        return c, nil
+    })
+}
+
+// NewCitation creates a new Citation held by the User with the given ID.
+func NewCitation( db *godis.Client, owner string, title string ) (*Citation, error) {
+    var c Citation
+
+    // get the next available resource ID
+    i64, err := db.Incr("nxRsrcId")
+    if err != nil {
+        return nil, err
+    }
+
+    // build the Citation
+    c.Identifier = strconv.FormatInt(i64, 10)
+    c.Owner = owner
+    c.Title = title
+    c.db = db
+
+    // return a pointer to the Citation
+    return &c, nil
+}
+
+// LoadCitation fetches the Citation with the given ID from the database. If
+// no such Citation exists, ErrNotFound is returned.
+func LoadCitation( db *godis.Client, id string ) (*Citation, error) {
+    var c Citation
+    c.Identifier = id
+    c.db = db
+
+    if err := LoadHash(db, c.GetKey(), &c); err != nil {
+        return nil, err
+    }
+
+    // return a pointer to the Citation
+    return &c, nil
+}
+
+// Validate checks that the Citation is complete and that its Provider exists.
+// Problems with the Citation itself are reported as a ValidationError.
+func (c *Citation) Validate() error {
+    if c.Title == "" {
+        return ValidationError("A Resource must have a title.")
+    }
+
+    // the URL is optional but must be absolute
+    if c.Url != "" {
+        u, err := url.Parse(c.Url)
+        if err != nil || u.Scheme == "" || u.Host == "" {
+            return ValidationError("The url of a Resource must be an absolute URL.")
+        }
+    }
+
+    // the referenced Provider must exist
+    if c.Provider == "" {
+        return ValidationError("A Resource must reference a Provider.")
+    }
+    exists, err := c.db.Exists(c.providerKey())
+    if err != nil {
+        return err
+    }
+    if ! exists {
+        return ValidationError("The referenced Provider does not exist.")
+    }
+
+    return nil
+}
+
+// providerKey returns the database key of the Provider of this Citation.
+func (c *Citation) providerKey() string {
+    return (&Provider{Identifier: c.Provider}).GetKey()
+}
+
+// References returns the keys of the User who owns this Citation, which is
+// deleted along with it, and of its Provider, which may not be deleted while
+// the Citation refers to it.
+func (c *Citation) References() []string {
+    refs := []string{(&User{Identifier: c.Owner}).GetKey()}
+    if c.Provider != "" {
+        refs = append(refs, c.providerKey())
+    }
+
+    return refs
+}
+
+// GetKey returns the database key for this Citation.
+func (c *Citation) GetKey() string {
+    return "rsrc:" + c.Identifier
+}
+
+// IndexKey returns the key of the sorted set indexing the Owner's Citations.
+func (c *Citation) IndexKey() string {
+    return "idx:User:" + c.Owner + ":Citation"
+}
+
+// Db returns a pointer to the database client.
+func (c *Citation) Db() *godis.Client {
+    return c.db
+}
+
+// Id returns the ID of this Citation.
+func (c *Citation) Id() string {
+    return c.Identifier
+}
+
+// Label returns the Title of this Citation.
+func (c *Citation) Label() string {
+    return c.Title
+}
+
+// Route returns the name of the route serving this Citation and its parameters.
+func (c *Citation) Route() (string, []string) {
+    return "resource", []string{c.Owner, c.Identifier}
+}
+
+// GetCitations returns one Page of the Citations of the User with the given
+// ID selected and sorted by query, along with the total number of them. The
+// view says what more of each Citation to include; their URIs are built from
+// the routes of srv.
+func GetCitations (db *godis.Client, srv *Server, owner string, query Query, page Page, view View) ([]Resource, int64, error) {
+    var citations []Resource
+
+    // fetch the Citations
+    var idx Citation
+    idx.Owner = owner
+    idx.Identifier = "*"
+    entries, total, err := QueryIndex(db, idx.IndexKey(), idx.GetKey(), query, page)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // loop through all results, creating resources
+    for _, e := range entries {
+        // build the Citation
+        var c Citation
+        c.Identifier = e.Id
+        c.Owner = owner
+        c.Title = e.Label
+
+        // create a resource from the citation, loading the whole citation if
+        // the view asks for more than its title
+        r := NewResource(srv, &c)
+        if ! view.Empty() {
+            full, err := LoadCitation(db, e.Id)
+            if err == ErrNotFound {
+                // deleted since the index was read
+                continue
+            } else if err != nil {
+                return nil, 0, err
+            }
+            if err := view.Apply(&r, full); err != nil {
+                return nil, 0, err
+            }
+        }
+
+        // add it to the array to return
+        citations = append(citations, r)
+    }
+
+    // return the array of citations
+    return citations, total, nil
+}
@@ -3,35 +3,165 @@ package main
 import (
     "godis"
     "encoding/json"
+    "encoding/xml"
+    "errors"
+    "io"
+    "io/ioutil"     // for reading request bodies
+    "net"           // for recognizing connection errors
     "os"            // for env vars
     "strconv"       // for converting string to int and int64 to string
     "log"
     "reflect"       // saving objects to db
 )
 
-// Marshal translates a Go type into a JSON byte array.
-func Marshal( T interface{} ) []byte {
-        // for development and testing, we'll use a prettier output
-	j, _ := json.MarshalIndent(T, "", "    ")
-        return j
+// PrettyOutput reports whether responses are indented for people to read
+// rather than compacted, unless a request asks otherwise with "?pretty=". It
+// can be set through the CITEPLASM_PRETTY environment variable.
+func PrettyOutput () bool {
+    pretty := os.Getenv("CITEPLASM_PRETTY")
+    if pretty == "" {
+        return false
+    }
+
+    // indentation is only cosmetic, so a typo here should not cost clients
+    // their responses
+    b, err := strconv.ParseBool(pretty)
+    if err != nil {
+        log.Printf("Environment variable CITEPLASM_PRETTY must be true or false; using false.")
+        return false
+    }
+
+    return b
+}
+
+// Marshal translates a Go type into a JSON byte array, indented if pretty is
+// true.
+func Marshal( T interface{}, pretty bool ) ([]byte, error) {
+    if pretty {
+        return json.MarshalIndent(T, "", "    ")
+    }
+
+    return json.Marshal(T)
+}
+
+// EncodeJson writes the JSON representation of T to w, indented if pretty is
+// true. Compact output is streamed to w, while indented output is built in
+// memory first.
+func EncodeJson( w io.Writer, T interface{}, pretty bool ) error {
+    if ! pretty {
+        return json.NewEncoder(w).Encode(T)
+    }
+
+    j, err := Marshal(T, true)
+    if err != nil {
+        return err
+    }
+    _, err = w.Write(j)
+    return err
+}
+
+// MarshalXml translates a Go type into an XML document, indented if pretty is
+// true. Element names follow the keys of the JSON representation, lists being
+// wrapped in an element of their own, e.g. <authors><author>...</author></authors>.
+func MarshalXml( T interface{}, pretty bool ) ([]byte, error) {
+    var x []byte
+    var err error
+    if pretty {
+        x, err = xml.MarshalIndent(T, "", "    ")
+    } else {
+        x, err = xml.Marshal(T)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    return append([]byte(xml.Header), x...), nil
+}
+
+// EncodeXml writes the XML representation of T to w, as MarshalXml does.
+// Compact output is streamed to w, while indented output is built in memory
+// first.
+func EncodeXml( w io.Writer, T interface{}, pretty bool ) error {
+    if ! pretty {
+        if _, err := io.WriteString(w, xml.Header); err != nil {
+            return err
+        }
+        return xml.NewEncoder(w).Encode(T)
+    }
+
+    x, err := MarshalXml(T, true)
+    if err != nil {
+        return err
+    }
+    _, err = w.Write(x)
+    return err
 }
 
+// Unmarshal reads a JSON document from r into the value pointed to by T.
+func Unmarshal( r io.Reader, T interface{} ) error {
+    body, err := ioutil.ReadAll(r)
+    if err != nil {
+        return err
+    }
+
+    return json.Unmarshal(body, T)
+}
+
+// ErrNotFound is returned when a requested object does not exist in the
+// database.
+var ErrNotFound = errors.New("object does not exist")
+
+// ValidationError is returned when an object provided by a client is
+// incomplete or inconsistent. Its text is suitable for returning to the
+// client.
+type ValidationError string
+
+// Error returns the explanation of the ValidationError.
+func (e ValidationError) Error() string {
+    return string(e)
+}
+
+// ApiVersion is the prefix under which the resources of this version of the
+// API are served.
+const ApiVersion = "/v1.0"
+
 // Resource is a generic reference to a resource represented by the API.
 type Resource struct {
         // Label is the friendly name for the resource.
-	Label string `json:"label"`
+	Label string `json:"label" xml:"label"`
 
         // Uri is the URI for this resource within this API. It is also a
         // unique identifier.
-	Uri   string `json:"uri"`
+	Uri   string `json:"uri" xml:"uri"`
+
+        // Fields are the fields of the resource a client asked for with
+        // "?fields=", if any; see View.
+	Fields Fields `json:"fields,omitempty" xml:"fields>field,omitempty"`
+
+        // Embedded is the complete resource, if a client asked for it with
+        // "?embed=full".
+	Embedded interface{} `json:"embedded,omitempty" xml:"embedded,omitempty"`
+
+        // Score is how well the resource matches a search, if it was found
+        // by one; see Search.
+	Score float64 `json:"score,omitempty" xml:"score,omitempty"`
+
+        // Snippet is the part of the resource that best matches a search, as
+        // HTML in which the matching words are emphasized.
+	Snippet string `json:"snippet,omitempty" xml:"snippet,omitempty"`
+
+        // Links are the hypermedia links of the resource, starting with
+        // "self".
+	Links Links  `json:"_links,omitempty" xml:"links>link,omitempty"`
 }
 
-// NewResource creates a new Resource from a struct that implements DbObject.
-func NewResource(obj DbObject) Resource {
+// NewResource creates a new Resource from a struct that implements DbObject,
+// its URI built from the routes of srv.
+func NewResource(srv *Server, obj DbObject) Resource {
     var r Resource
     r.Label = obj.Label()
-    // FIXME: there's probably a better way to integrate the API version.
-    r.Uri = obj.Uri()
+    r.Uri = srv.ObjectHref(obj)
+    r.Links = Links{Link{Rel: "self", Href: r.Uri}}
 
     return r
 }
@@ -39,31 +169,63 @@ func NewResource(obj DbObject) Resource {
 // MessageSuccess represents a successful request for a resultset.
 type MessageSuccess struct {
 
+        // XMLName names the root element of the XML representation.
+	XMLName xml.Name   `json:"-" xml:"response"`
+
         // Msg is the human-readable response, often just "success"
-	Msg     string     `json:"msg"`
+	Msg     string     `json:"msg" xml:"msg"`
 
         // Results is an array of Resources associated with this message.
-	Results []Resource `json:"results"`
+	Results []Resource `json:"results" xml:"results>resource"`
+
+        // Total is the number of Resources in the whole collection, of which
+        // Results may only be one page.
+	Total   int64      `json:"total" xml:"total"`
+
+        // Next is the URI of the next page of the collection, if any.
+	Next    string     `json:"next,omitempty" xml:"next,omitempty"`
+
+        // Prev is the URI of the previous page of the collection, if any.
+	Prev    string     `json:"prev,omitempty" xml:"prev,omitempty"`
+
+        // Links are the hypermedia links of the message, e.g. to the page
+        // itself, the next and previous pages, and the route for creating
+        // a Resource in the collection.
+	Links   Links      `json:"_links,omitempty" xml:"links>link,omitempty"`
 }
 
-// Json provides the JSON version of the MessageSuccess in a byte array.
-func (msg *MessageSuccess) Json() []byte {
-	return Marshal(msg)
+// MessageObject represents a successful request for a single object.
+type MessageObject struct {
+
+        // XMLName names the root element of the XML representation.
+	XMLName xml.Name    `json:"-" xml:"response"`
+
+        // Msg is the human-readable response, often just "success"
+	Msg     string      `json:"msg" xml:"msg"`
+
+        // Result is the complete object requested.
+	Result  interface{} `json:"result" xml:"result"`
+
+        // Links are the hypermedia links of the message, e.g. to the object
+        // itself, its collection, and the routes for editing it.
+	Links   Links       `json:"_links,omitempty" xml:"links>link,omitempty"`
 }
 
 // MessageError represents a transaction that could not be fulfilled.
 type MessageError struct {
 
+        // XMLName names the root element of the XML representation.
+	XMLName xml.Name `json:"-" xml:"error"`
+
         // Code is the error code indicating the error.
-	Code    int    `json:"code"`
+	Code    int    `json:"code" xml:"code"`
 
         // Message is the human-readable error explaining what went wrong.
-	Message string `json:"msg"`
-}
+	Message string `json:"msg" xml:"msg"`
 
-// Json provides the JSON version of the MessageError in a byte array.
-func (msg *MessageError) Json() []byte {
-	return Marshal(msg)
+        // RequestId identifies the request that failed, so that it can be
+        // found in the server log.
+	RequestId string `json:"request_id,omitempty" xml:"request_id,omitempty"`
 }
 
 // DbConnect returns a connection to the Redis database. Connection details can
@@ -88,6 +250,18 @@ func DbConnect () *godis.Client {
     return godis.New(addr, dbi, pw)
 }
 
+// DbUnavailable reports whether err was caused by the database being
+// unreachable, as opposed to a problem with a particular request.
+func DbUnavailable (err error) bool {
+    // connection failures and timeouts are network errors
+    if _, ok := err.(net.Error); ok {
+        return true
+    }
+
+    // the connection was dropped midway through a reply
+    return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
 // DbObject is the basic interface for all objects that persist to the database.
 type DbObject interface {
     // GetKey returns the database key for the object, e.g. "user:1234" or "prov:5678"
@@ -102,63 +276,203 @@ type DbObject interface {
     // Label returns the human-friendly name of this object, used in Resource values.
     Label() string
 
-    // Uri returns the API address of the object.
-    Uri() string
+    // Route returns the name of the route serving the object and its
+    // parameters, from which its URI is built; see Server.ObjectHref.
+    Route() (string, []string)
+}
+
+// Recorder is implemented by DbObjects that keep more in the database than
+// their hash and index entry, such as the Username a User claims, so that it
+// is written in the same transaction as the hash.
+type Recorder interface {
+    // QueueRecord queues the commands that record the object, as part of the
+    // transaction in pipe that saves it.
+    QueueRecord(pipe *godis.PipeClient)
+
+    // QueueUnrecord queues the commands that undo QueueRecord, as part of the
+    // transaction in pipe that deletes the object.
+    QueueUnrecord(pipe *godis.PipeClient)
 }
 
 // SaveHashes pushes one or more documents as a hash to the database. All
-// variables must have types that implement DbObject.
-// FIXME: support transactions
+// variables must have types that implement DbObject. Fields are stored
+// according to their "db" struct tags; see Hash.go. The documents are written
+// in a single transaction, so either all of them are saved or none are; they
+// must therefore share a database connection. A DanglingError is returned if
+// a document refers to one that does not exist; see Referrer.
 func SaveHashes(objs ...DbObject) error {
-    // run through all provided DbObjects and persist them
-    for i := 0; i < len(objs); i++ {
-        // convenience representation of the current DbObject
-        obj := objs[i]
+    return writeHashes(nil, objs)
+}
 
-        // get the DB key for this hash
-        key := obj.GetKey()
+// DeleteHashes removes one or more documents from the database, along with
+// their entries in the index, in a single transaction. All variables must have
+// types that implement DbObject and share a database connection. If other
+// documents still refer to one of them, ErrReferenced is returned and nothing
+// is deleted; see Referrer.
+func DeleteHashes(objs ...DbObject) error {
+    return writeHashes(objs, nil)
+}
 
-        // get the reflect.Value and reflect.Type of the obj
-        oVal := reflect.ValueOf(obj)
-        oTyp := reflect.TypeOf(obj)
+// ReplaceHash removes the document old and saves obj in its place in a single
+// transaction, so that fields left out of obj and the index entry of old do
+// not linger.
+func ReplaceHash(old DbObject, obj DbObject) error {
+    return writeHashes([]DbObject{old}, []DbObject{obj})
+}
 
-        // if it's a pointer to a value, resolve to the value and type itself,
-        // rather than pointer
-        if oTyp.Kind() == reflect.Ptr {
-            oTyp = oTyp.Elem()
+// writeHashes deletes the documents in del and then saves those in save, all
+// within one MULTI/EXEC transaction.
+func writeHashes(del []DbObject, save []DbObject) error {
+    if len(del) == 0 && len(save) == 0 {
+        return nil
+    }
+
+    // convert all the fields before anything is sent to the database, so that
+    // a field that cannot be stored leaves the database untouched
+    encoded := make([]map[string]string, len(save))
+    for i := 0; i < len(save); i++ {
+        // get the reflect.Value of the obj, resolving a pointer to the value
+        // itself
+        oVal := reflect.ValueOf(save[i])
+        if oVal.Kind() == reflect.Ptr {
             oVal = oVal.Elem()
         }
 
-        // cycle through all the fields and insert them into the hash in the db
-        oFieldCount := oTyp.NumField()
-        for i := 0; i < oFieldCount; i++ {
-            // get the field Value and Type
-            fv := oVal.Field(i)
-            ft := oTyp.Field(i)
-
-            // if it's not a string, we don't care
-            // FIXME: convertible types like int should be included as well
-            if fv.Type().Kind() != reflect.String {
-                continue
-            }
-
-            // if the field has no value or if it's the Identifier field, skip it
-            if fv.String() != "" && ft.Name != "Identifier" {
-                obj.Db().Hset(key, ft.Name, fv.String())
-            }
+        fields, err := encodeHash(oVal)
+        if err != nil {
+            return errors.New("cannot save " + save[i].GetKey() + ": " + err.Error())
         }
+        encoded[i] = fields
+    }
 
-        // insert into the index so it can be found without knowing its key
-        // the list is at "idx:Type" (e.g. idx:User) and new value is "Id|Label" (e.g. "1234|johnsmith")
-        idxKeyName := "idx:" + oTyp.Name()
-        idxKeyValue := obj.Id() + "|" + obj.Label()
-        _, err := obj.Db().Lpush(idxKeyName, idxKeyValue)
-        if err != nil {
+    // all documents share the connection, so use the first one's
+    var db *godis.Client
+    if len(del) > 0 {
+        db = del[0].Db()
+    } else {
+        db = save[0].Db()
+    }
+
+    // write everything in a transaction, trying again if another one changed
+    // the search terms or references of the documents after they were read
+    objs := append(append([]DbObject{}, del...), save...)
+    for attempt := 0; attempt < writeAttempts; attempt++ {
+        done, err := tryWriteHashes(db, objs, del, save, encoded)
+        if done || err != nil {
             return err
         }
     }
 
-    // there was obviously no error, so return nil
-    return nil
+    return errors.New("cannot save " + objs[0].GetKey() + ": it was changed by too many other requests")
 }
 
+// writeAttempts is the number of times writeHashes tries its transaction.
+const writeAttempts = 5
+
+// tryWriteHashes makes one attempt at the transaction of writeHashes, objs
+// being del and save together and encoded the fields of save. It reports
+// whether the transaction ran; it does not if the search terms or the
+// references of objs were changed in the meantime.
+func tryWriteHashes(db *godis.Client, objs []DbObject, del []DbObject, save []DbObject, encoded []map[string]string) (bool, error) {
+    pipe := godis.NewPipeClientFromClient(db)
+
+    // make sure no reference is left dangling
+    if err := watchReferences(pipe, del, save); err != nil {
+        return false, err
+    }
+    if err := checkReferences(db, del, save); err != nil {
+        return false, err
+    }
+
+    // find the terms the documents are searchable by now, which are replaced
+    if err := watchSearchTerms(pipe, objs); err != nil {
+        return false, err
+    }
+    searched, err := readSearchTerms(db, objs)
+    if err != nil {
+        return false, err
+    }
+
+    // queue up all the commands in a transaction
+    if err := pipe.Multi(); err != nil {
+        return false, err
+    }
+
+    for key, terms := range searched {
+        queueUnsearch(pipe, key, terms)
+    }
+
+    for _, obj := range del {
+        // remove the object from its index and the hash itself
+        queueUnindex(pipe, obj)
+        if r, ok := obj.(Recorder); ok {
+            r.QueueUnrecord(pipe)
+        }
+        pipe.Del(obj.GetKey())
+    }
+
+    for i, obj := range save {
+        // insert the fields into the hash
+        key := obj.GetKey()
+        for name, value := range encoded[i] {
+            pipe.Hset(key, name, value)
+        }
+
+        // insert into the index so it can be found without knowing its key,
+        // and into the search index so it can be found by its words
+        queueIndex(pipe, obj)
+        queueSearch(pipe, obj)
+        if r, ok := obj.(Recorder); ok {
+            r.QueueRecord(pipe)
+        }
+    }
+
+    // run the transaction and make sure every command succeeded; there is
+    // always at least one, so no replies means a watched key changed
+    replies, err := pipe.Exec()
+    if err != nil {
+        return false, err
+    }
+    if len(replies) == 0 {
+        return false, nil
+    }
+    for _, r := range replies {
+        if r.Err != nil {
+            return true, r.Err
+        }
+    }
+
+    return true, nil
+}
+
+// LoadHash fills obj, which must be a pointer to a struct implementing
+// DbObject, from the hash stored at key. Each field is read and converted
+// according to its "db" struct tag, as with SaveHashes; fields absent from the
+// hash are left untouched. If there is no hash at key, ErrNotFound is
+// returned.
+func LoadHash(db *godis.Client, key string, obj DbObject) error {
+    // we can only fill in a struct that we were given a pointer to
+    oVal := reflect.ValueOf(obj)
+    if oVal.Kind() != reflect.Ptr || oVal.Elem().Kind() != reflect.Struct {
+        return errors.New("LoadHash requires a pointer to a struct, not " + oVal.Type().String())
+    }
+
+    // fetch the whole hash
+    r, err := db.Hgetall(key)
+    if err != nil {
+        return err
+    }
+
+    // an empty hash means the object does not exist
+    fields := r.StringMap()
+    if len(fields) == 0 {
+        return ErrNotFound
+    }
+
+    // fill in the struct from the hash
+    if err := decodeHash(fields, oVal.Elem()); err != nil {
+        return errors.New("cannot load " + key + ": " + err.Error())
+    }
+
+    return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "log"
+    "strings"
+)
+
+// Group registers routes sharing a prefix, e.g. the version of the API, and
+// middleware. The group's middleware runs after the Server's global
+// middleware, and after that of any enclosing Group, but before the
+// middleware given for each route.
+type Group struct {
+    // srv is the Server the routes are registered with.
+    srv *Server
+
+    // prefix is prepended to the route of every Handler of the Group.
+    prefix string
+
+    // middleware is run, in order, before the middleware of every route of
+    // the Group.
+    middleware []Middleware
+}
+
+// Group creates a Group of routes under the given prefix, such as "/v1.0",
+// whose handlers are run after the given middleware.
+func (srv *Server) Group (prefix string, mw ...Middleware) *Group {
+    return newGroup(srv, "", nil, prefix, mw)
+}
+
+// Group creates a Group of routes nested within this one, so that its prefix
+// and middleware follow those of this Group.
+func (g *Group) Group (prefix string, mw ...Middleware) *Group {
+    return newGroup(g.srv, g.prefix, g.middleware, prefix, mw)
+}
+
+// newGroup creates a Group whose prefix and middleware extend those given.
+func newGroup (srv *Server, outer string, outerMw []Middleware, prefix string, mw []Middleware) *Group {
+    if ! strings.HasPrefix(prefix, "/") {
+        log.Fatalf("Group prefix %s must start with /", prefix)
+    }
+
+    // copy the middleware so that sibling groups do not share it
+    middleware := make([]Middleware, 0, len(outerMw) + len(mw))
+    middleware = append(middleware, outerMw...)
+    middleware = append(middleware, mw...)
+
+    return &Group{srv, outer + strings.TrimRight(prefix, "/"), middleware}
+}
+
+// addRoute adds a handler for the route within the Group's prefix, after the
+// Group's middleware and then mw. The route "/" stands for the prefix itself.
+func (g *Group) addRoute (method string, uri string, handler interface{}, mw []Middleware) Route {
+    route := g.prefix + uri
+    if uri == "/" && g.prefix != "" {
+        route = g.prefix
+    }
+
+    middleware := make([]Middleware, 0, len(g.middleware) + len(mw))
+    middleware = append(middleware, g.middleware...)
+    middleware = append(middleware, mw...)
+
+    return g.srv.addRoute(method, route, handler, middleware)
+}
+
+// Get adds a new handler for a GET request to the specified URI within the
+// Group. Any middleware provided is run, in order, after the Group's. The Route
+// is returned so that it can be named.
+func (g *Group) Get (uri string, handler interface{}, mw ...Middleware) Route {
+    return g.addRoute("GET", uri, handler, mw)
+}
+
+// Post adds a new handler for a POST request to the specified URI within the
+// Group. Any middleware provided is run, in order, after the Group's. The Route
+// is returned so that it can be named.
+func (g *Group) Post (uri string, handler interface{}, mw ...Middleware) Route {
+    return g.addRoute("POST", uri, handler, mw)
+}
+
+// Put adds a new handler for a PUT request to the specified URI within the
+// Group. Any middleware provided is run, in order, after the Group's. The Route
+// is returned so that it can be named.
+func (g *Group) Put (uri string, handler interface{}, mw ...Middleware) Route {
+    return g.addRoute("PUT", uri, handler, mw)
+}
+
+// Patch adds a new handler for a PATCH request to the specified URI within the
+// Group. Any middleware provided is run, in order, after the Group's. The Route
+// is returned so that it can be named.
+func (g *Group) Patch (uri string, handler interface{}, mw ...Middleware) Route {
+    return g.addRoute("PATCH", uri, handler, mw)
+}
+
+// Delete adds a new handler for a DELETE request to the specified URI within
+// the Group. Any middleware provided is run, in order, after the Group's. The
+// Route is returned so that it can be named.
+func (g *Group) Delete (uri string, handler interface{}, mw ...Middleware) Route {
+    return g.addRoute("DELETE", uri, handler, mw)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+    "encoding/json" // lists are stored as JSON arrays
+    "fmt"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Fields of objects persisted with SaveHashes are stored in the hash under
+// their Go name unless a "db" struct tag says otherwise:
+//
+//     Name  string    `db:"name"`            // stored as "name"
+//     Email string    `db:",omitempty"`       // not stored when empty
+//     Saved time.Time `db:"saved,omitempty"`  // not stored when zero
+//     Cache string    `db:"-"`                // never stored
+//
+// Strings, bools, integers, floats, and time.Time values are stored as text,
+// slices of those as a JSON array. Times are stored in UTC with all nine
+// digits of their nanoseconds, so that they sort as text in time order.
+// Fields of embedded structs are stored as if they were fields of the outer
+// struct, while those of other struct fields are stored as "Outer.Inner".
+// Unexported fields, including unexported embedded structs, are never stored.
+
+// timeType is the reflect.Type of time.Time, which is stored as RFC 3339 text
+// rather than as a nested struct.
+var timeType = reflect.TypeOf(time.Time{})
+
+// timeLayout is the layout times are stored in: RFC 3339 with a fixed number
+// of fractional digits, as time.RFC3339Nano drops trailing zeros and so
+// e.g. "05.5Z" would sort before "05Z".
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// hashTag returns the name under which the field is stored, whether it is
+// only stored when non-zero, and whether it is stored at all.
+func hashTag(ft reflect.StructField) (name string, omitEmpty bool, skip bool) {
+    tag := ft.Tag.Get("db")
+    if tag == "-" {
+        return "", false, true
+    }
+
+    parts := strings.Split(tag, ",")
+    name = parts[0]
+    if name == "" {
+        name = ft.Name
+    }
+    for _, opt := range parts[1:] {
+        if opt == "omitempty" {
+            omitEmpty = true
+        }
+    }
+
+    return name, omitEmpty, false
+}
+
+// encodeHash converts the struct v into the fields and values of a hash.
+func encodeHash(v reflect.Value) (map[string]string, error) {
+    fields := make(map[string]string)
+    if err := encodeStruct(v, "", fields); err != nil {
+        return nil, err
+    }
+
+    return fields, nil
+}
+
+// encodeStruct adds the fields of the struct v to fields, prefixing their
+// names with prefix.
+func encodeStruct(v reflect.Value, prefix string, fields map[string]string) error {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        fv := v.Field(i)
+        ft := t.Field(i)
+
+        // unexported fields, like the db connection, are never stored
+        if ft.PkgPath != "" {
+            continue
+        }
+
+        // embedded structs are stored as part of the outer struct
+        if ft.Anonymous && ft.Type.Kind() == reflect.Struct && ft.Type != timeType {
+            if err := encodeStruct(fv, prefix, fields); err != nil {
+                return err
+            }
+            continue
+        }
+
+        name, omitEmpty, skip := hashTag(ft)
+        if skip {
+            continue
+        }
+        name = prefix + name
+
+        // other structs are stored field by field as "Outer.Inner"
+        if ft.Type.Kind() == reflect.Struct && ft.Type != timeType {
+            if err := encodeStruct(fv, name + ".", fields); err != nil {
+                return err
+            }
+            continue
+        }
+
+        if omitEmpty && isEmptyValue(fv) {
+            continue
+        }
+
+        value, err := encodeValue(fv)
+        if err != nil {
+            return fmt.Errorf("cannot store field %s: %s", name, err)
+        }
+        fields[name] = value
+    }
+
+    return nil
+}
+
+// encodeValue converts a single field value into its text representation.
+func encodeValue(fv reflect.Value) (string, error) {
+    if fv.Type() == timeType {
+        return fv.Interface().(time.Time).UTC().Format(timeLayout), nil
+    }
+
+    switch fv.Kind() {
+    case reflect.String:
+        return fv.String(), nil
+
+    case reflect.Bool:
+        return strconv.FormatBool(fv.Bool()), nil
+
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return strconv.FormatInt(fv.Int(), 10), nil
+
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return strconv.FormatUint(fv.Uint(), 10), nil
+
+    case reflect.Float32, reflect.Float64:
+        return strconv.FormatFloat(fv.Float(), 'g', -1, fv.Type().Bits()), nil
+
+    case reflect.Slice:
+        if ! isScalarKind(fv.Type().Elem().Kind()) {
+            return "", fmt.Errorf("unsupported slice of %s", fv.Type().Elem().Kind())
+        }
+        j, err := json.Marshal(fv.Interface())
+        if err != nil {
+            return "", err
+        }
+        return string(j), nil
+    }
+
+    return "", fmt.Errorf("unsupported kind %s", fv.Kind())
+}
+
+// decodeHash fills the struct v from the fields and values of a hash. Fields
+// absent from the hash are left untouched.
+func decodeHash(fields map[string]string, v reflect.Value) error {
+    return decodeStruct(fields, v, "")
+}
+
+// decodeStruct fills the struct v from fields, whose names are prefixed with
+// prefix.
+func decodeStruct(fields map[string]string, v reflect.Value, prefix string) error {
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        fv := v.Field(i)
+        ft := t.Field(i)
+
+        // unexported fields, like the db connection, cannot be set
+        if ft.PkgPath != "" {
+            continue
+        }
+
+        // embedded structs are stored as part of the outer struct
+        if ft.Anonymous && ft.Type.Kind() == reflect.Struct && ft.Type != timeType {
+            if err := decodeStruct(fields, fv, prefix); err != nil {
+                return err
+            }
+            continue
+        }
+
+        name, _, skip := hashTag(ft)
+        if skip {
+            continue
+        }
+        name = prefix + name
+
+        // other structs are stored field by field as "Outer.Inner"
+        if ft.Type.Kind() == reflect.Struct && ft.Type != timeType {
+            if err := decodeStruct(fields, fv, name + "."); err != nil {
+                return err
+            }
+            continue
+        }
+
+        value, ok := fields[name]
+        if ! ok {
+            continue
+        }
+
+        if err := decodeValue(fv, value); err != nil {
+            return fmt.Errorf("cannot load field %s: %s", name, err)
+        }
+    }
+
+    return nil
+}
+
+// decodeValue converts the text representation of a single field value to
+// the type of fv and sets it.
+func decodeValue(fv reflect.Value, value string) error {
+    if fv.Type() == timeType {
+        // times stored before timeLayout have fewer fractional digits,
+        // which time.RFC3339Nano also reads
+        t, err := time.Parse(time.RFC3339Nano, value)
+        if err != nil {
+            return err
+        }
+        fv.Set(reflect.ValueOf(t))
+        return nil
+    }
+
+    switch fv.Kind() {
+    case reflect.String:
+        fv.SetString(value)
+
+    case reflect.Bool:
+        b, err := strconv.ParseBool(value)
+        if err != nil {
+            return err
+        }
+        fv.SetBool(b)
+
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+        if err != nil {
+            return err
+        }
+        fv.SetInt(n)
+
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+        if err != nil {
+            return err
+        }
+        fv.SetUint(n)
+
+    case reflect.Float32, reflect.Float64:
+        f, err := strconv.ParseFloat(value, fv.Type().Bits())
+        if err != nil {
+            return err
+        }
+        fv.SetFloat(f)
+
+    case reflect.Slice:
+        if ! isScalarKind(fv.Type().Elem().Kind()) {
+            return fmt.Errorf("unsupported slice of %s", fv.Type().Elem().Kind())
+        }
+        if err := json.Unmarshal([]byte(value), fv.Addr().Interface()); err != nil {
+            return err
+        }
+
+    default:
+        return fmt.Errorf("unsupported kind %s", fv.Kind())
+    }
+
+    return nil
+}
+
+// isScalarKind reports whether values of kind k can be stored in a JSON
+// array.
+func isScalarKind(k reflect.Kind) bool {
+    switch k {
+    case reflect.String, reflect.Bool,
+        reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+        reflect.Float32, reflect.Float64:
+        return true
+    }
+
+    return false
+}
+
+// isEmptyValue reports whether fv holds the zero value of its type, for the
+// purposes of the omitempty option.
+func isEmptyValue(fv reflect.Value) bool {
+    if fv.Type() == timeType {
+        return fv.Interface().(time.Time).IsZero()
+    }
+
+    switch fv.Kind() {
+    case reflect.String, reflect.Slice:
+        return fv.Len() == 0
+    case reflect.Bool:
+        return ! fv.Bool()
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        return fv.Int() == 0
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        return fv.Uint() == 0
+    case reflect.Float32, reflect.Float64:
+        return fv.Float() == 0
+    }
+
+    return false
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"gospec"
+	. "gospec"
+	"reflect"
+	"time"
+)
+
+// HashStamp is embedded in hashSample to exercise flattening. It is exported
+// because the fields of unexported embedded structs are not stored.
+type HashStamp struct {
+	Saved time.Time `db:"saved,omitempty"`
+}
+
+// hashPoint is nested in hashSample to exercise "Outer.Inner" names.
+type hashPoint struct {
+	X float64
+	Y float64
+}
+
+// hashSample is a struct using every kind of field SaveHashes supports.
+type hashSample struct {
+	HashStamp
+	Identifier string `db:"-"`
+	Name       string `db:"name"`
+	Note       string `db:",omitempty"`
+	Count      int
+	Size       uint16
+	Ratio      float64
+	Public     bool
+	Tags       []string
+	Scores     []int `db:",omitempty"`
+	Where      hashPoint
+	cache      string
+}
+
+// HashSpec specifies how struct fields are stored in and loaded from hashes.
+func HashSpec(c gospec.Context) {
+	saved := time.Date(2011, time.December, 24, 18, 30, 0, 500, time.UTC)
+	sample := hashSample{
+		HashStamp:  HashStamp{saved},
+		Identifier: "1234",
+		Name:       "sample",
+		Count:      -42,
+		Size:       7,
+		Ratio:      0.25,
+		Public:     true,
+		Tags:       []string{"one", "two"},
+		Where:      hashPoint{1.5, -2},
+		cache:      "ignored",
+	}
+
+	fields, err := encodeHash(reflect.ValueOf(sample))
+	c.Assume(err, IsNil)
+
+	c.Specify("names fields by their tag or their Go name", func() {
+		c.Expect(fields["name"], Equals, "sample")
+		c.Expect(fields["Count"], Equals, "-42")
+		c.Expect(fields["Size"], Equals, "7")
+		c.Expect(fields["Public"], Equals, "true")
+		c.Expect(fields["Tags"], Equals, `["one","two"]`)
+	})
+
+	c.Specify("flattens embedded structs and prefixes nested ones", func() {
+		c.Expect(fields["saved"], Equals, "2011-12-24T18:30:00.000000500Z")
+		c.Expect(fields["Where.X"], Equals, "1.5")
+		c.Expect(fields["Where.Y"], Equals, "-2")
+	})
+
+	c.Specify("skips ignored, unexported, and empty omitempty fields", func() {
+		_, hasId := fields["Identifier"]
+		_, hasCache := fields["cache"]
+		_, hasNote := fields["Note"]
+		_, hasScores := fields["Scores"]
+		c.Expect(hasId, IsFalse)
+		c.Expect(hasCache, IsFalse)
+		c.Expect(hasNote, IsFalse)
+		c.Expect(hasScores, IsFalse)
+	})
+
+	c.Specify("loads the stored fields back into an equal struct", func() {
+		var loaded hashSample
+		err := decodeHash(fields, reflect.ValueOf(&loaded).Elem())
+		c.Expect(err, IsNil)
+
+		sample.Identifier = ""
+		sample.cache = ""
+		c.Expect(loaded.Saved.Equal(saved), IsTrue)
+		loaded.Saved = sample.Saved
+		c.Expect(reflect.DeepEqual(loaded, sample), IsTrue)
+	})
+
+	c.Specify("stores times so that they sort as text in time order", func() {
+		var encoded []string
+		for _, d := range []time.Duration{0, 250 * time.Millisecond, 500 * time.Millisecond, time.Second, 1500 * time.Millisecond} {
+			v, err := encodeValue(reflect.ValueOf(saved.Add(d)))
+			c.Expect(err, IsNil)
+			encoded = append(encoded, v)
+		}
+		for i := 1; i < len(encoded); i++ {
+			c.Expect(encoded[i-1] < encoded[i], IsTrue)
+		}
+
+		// times in other zones are stored in UTC
+		v, _ := encodeValue(reflect.ValueOf(saved.In(time.FixedZone("EST", -5*60*60))))
+		c.Expect(v, Equals, fields["saved"])
+
+		// and times stored with fewer digits can still be read
+		var loaded hashSample
+		err := decodeHash(map[string]string{"saved": "2011-12-24T18:30:00.5Z"}, reflect.ValueOf(&loaded).Elem())
+		c.Expect(err, IsNil)
+		c.Expect(loaded.Saved.Equal(saved.Add(500*time.Millisecond - 500)), IsTrue)
+	})
+
+	c.Specify("returns an error for unsupported kinds", func() {
+		var unsupported struct {
+			Lookup map[string]string
+		}
+		_, err := encodeHash(reflect.ValueOf(unsupported))
+		c.Expect(err, Not(IsNil))
+
+		err = decodeHash(map[string]string{"Lookup": "{}"}, reflect.ValueOf(&unsupported).Elem())
+		c.Expect(err, Not(IsNil))
+	})
+
+	c.Specify("returns an error for malformed values", func() {
+		var loaded hashSample
+		err := decodeHash(map[string]string{"Count": "many"}, reflect.ValueOf(&loaded).Elem())
+		c.Expect(err, Not(IsNil))
+	})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+    "errors"
+    "godis"
+    "reflect"
+    "strings"
+)
+
+// Every DbObject is indexed so it can be found without knowing its key. An
+// index is made of two parts: a sorted set at "idx:Type" (e.g. idx:User) of
+// the IDs of the objects, scored by ID, and a hash at "idx:Type:labels" from
+// each ID to the object's Label. Both are keyed by ID, so saving an object
+// again, even after it was renamed, never duplicates its entry.
+
+// Indexer is implemented by DbObjects that are indexed somewhere other than
+// the default "idx:Type", such as objects that belong to a User.
+type Indexer interface {
+    // IndexKey returns the database key of the index of this object.
+    IndexKey() string
+}
+
+// indexKey returns the key of the index of obj: its IndexKey if it implements
+// Indexer, or "idx:Type" (e.g. idx:User) otherwise.
+func indexKey(obj DbObject) string {
+    if idx, ok := obj.(Indexer); ok {
+        return idx.IndexKey()
+    }
+
+    oTyp := reflect.TypeOf(obj)
+    if oTyp.Kind() == reflect.Ptr {
+        oTyp = oTyp.Elem()
+    }
+
+    return "idx:" + oTyp.Name()
+}
+
+// labelsKey returns the key of the hash of labels of the index at key.
+func labelsKey(key string) string {
+    return key + ":labels"
+}
+
+// queueIndex adds obj to its index, or updates its Label if it is already
+// there, and to the referrers of the objects it refers to, as part of the
+// transaction in pipe.
+func queueIndex(pipe *godis.PipeClient, obj DbObject) {
+    key := indexKey(obj)
+    pipe.Zadd(key, obj.Id(), obj.Id())
+    pipe.Hset(labelsKey(key), obj.Id(), obj.Label())
+
+    for _, ref := range references(obj) {
+        pipe.Sadd(referrersKey(ref), obj.GetKey())
+    }
+}
+
+// queueUnindex removes obj from its index and from the referrers of the
+// objects it refers to as part of the transaction in pipe.
+func queueUnindex(pipe *godis.PipeClient, obj DbObject) {
+    key := indexKey(obj)
+    pipe.Zrem(key, obj.Id())
+    pipe.Hdel(labelsKey(key), obj.Id())
+
+    for _, ref := range references(obj) {
+        pipe.Srem(referrersKey(ref), obj.GetKey())
+    }
+}
+
+// Referrer is implemented by DbObjects that refer to other objects, such as a
+// Citation to its Provider. The keys of the objects referring to each object
+// are indexed in a set at "idx:refs:KEY" (e.g. idx:refs:prov:1001), so that
+// an object is not deleted while others refer to it, nor saved while an object
+// it refers to is missing.
+type Referrer interface {
+    // References returns the database keys of the objects this object refers
+    // to.
+    References() []string
+}
+
+// ErrReferenced is returned when deleting an object that other objects still
+// refer to.
+var ErrReferenced = errors.New("object is still referred to")
+
+// DanglingError is returned when saving an object that refers to one that
+// does not exist. It holds the key of the missing object.
+type DanglingError string
+
+// Error returns the explanation of the DanglingError.
+func (e DanglingError) Error() string {
+    return "the referred object " + string(e) + " does not exist"
+}
+
+// references returns the keys of the objects obj refers to, if any.
+func references(obj DbObject) []string {
+    if r, ok := obj.(Referrer); ok {
+        return r.References()
+    }
+
+    return nil
+}
+
+// referrersKey returns the key of the set of the keys of the objects
+// referring to the object at key.
+func referrersKey(key string) string {
+    return "idx:refs:" + key
+}
+
+// watchReferences WATCHes, on the connection of pipe, the referrers of the
+// objects in del and the objects referred to by those in save, so that a
+// transaction on it fails if checkReferences would no longer pass.
+func watchReferences(pipe *godis.PipeClient, del []DbObject, save []DbObject) error {
+    var keys []string
+    for _, obj := range del {
+        keys = append(keys, referrersKey(obj.GetKey()))
+    }
+    for _, obj := range save {
+        keys = append(keys, references(obj)...)
+    }
+    if len(keys) == 0 {
+        return nil
+    }
+
+    return pipe.Watch(keys...)
+}
+
+// checkReferences makes sure that deleting the objects in del and then saving
+// those in save leaves no reference to a missing object. Objects in del that
+// are saved again are replaced rather than deleted. ErrReferenced or a
+// DanglingError is returned otherwise.
+func checkReferences(db *godis.Client, del []DbObject, save []DbObject) error {
+    saved := make(map[string]bool)
+    for _, obj := range save {
+        saved[obj.GetKey()] = true
+    }
+    deleted := make(map[string]bool)
+    for _, obj := range del {
+        if ! saved[obj.GetKey()] {
+            deleted[obj.GetKey()] = true
+        }
+    }
+
+    // whatever refers to a deleted object must be deleted along with it
+    for key := range deleted {
+        r, err := db.Smembers(referrersKey(key))
+        if err != nil {
+            return err
+        }
+        for _, referrer := range r.StringArray() {
+            if ! deleted[referrer] {
+                return ErrReferenced
+            }
+        }
+    }
+
+    // and whatever is referred to must remain
+    for _, obj := range save {
+        for _, ref := range references(obj) {
+            if saved[ref] {
+                continue
+            }
+            exists, err := db.Exists(ref)
+            if err != nil {
+                return err
+            }
+            if ! exists || deleted[ref] {
+                return DanglingError(ref)
+            }
+        }
+    }
+
+    return nil
+}
+
+// IndexEntry is a single entry of an index.
+type IndexEntry struct {
+    Id    string
+    Label string
+}
+
+// ReadIndex returns one Page of the entries of the index at key, newest (i.e.
+// highest ID) first, along with the total number of entries in the index.
+func ReadIndex(db *godis.Client, key string, page Page) ([]IndexEntry, int64, error) {
+    // count all the entries
+    total, err := db.Zcard(key)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // fetch the IDs
+    r, err := db.Zrevrange(key, page.Offset, page.Offset + page.Limit - 1)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    entries, err := readLabels(db, key, r.StringArray())
+    if err != nil {
+        return nil, 0, err
+    }
+
+    return entries, total, nil
+}
+
+// readLabels returns the entries of the index at key with the given IDs, in
+// the same order.
+func readLabels(db *godis.Client, key string, ids []string) ([]IndexEntry, error) {
+    var entries []IndexEntry
+    if len(ids) == 0 {
+        return entries, nil
+    }
+
+    // fetch their labels
+    r, err := db.Hmget(labelsKey(key), ids...)
+    if err != nil {
+        return nil, err
+    }
+    labels := r.StringArray()
+    if len(labels) != len(ids) {
+        return nil, errors.New("the labels of " + key + " do not match its IDs")
+    }
+
+    for i := 0; i < len(ids); i++ {
+        entries = append(entries, IndexEntry{ids[i], labels[i]})
+    }
+
+    return entries, nil
+}
+
+// Model describes a type of DbObject stored in the database, for routines
+// such as Reindex that work on every stored object.
+type Model struct {
+    // Prefix is the part of the database key that precedes the ID, e.g.
+    // "prov:".
+    Prefix string
+
+    // Load fetches the object with the given ID from the database.
+    Load func(db *godis.Client, id string) (DbObject, error)
+}
+
+// Models holds every registered Model.
+var Models []Model
+
+// RegisterModel adds a Model to Models. It is meant to be called from the
+// init function of the file defining the DbObject.
+func RegisterModel(prefix string, load func(db *godis.Client, id string) (DbObject, error)) {
+    Models = append(Models, Model{prefix, load})
+}
+
+// Reindex rebuilds every index ("idx:*"), and the search index ("search:*"),
+// from the hashes of the objects of all registered Models. The old indexes are
+// replaced in a single transaction.
+func Reindex(db *godis.Client) error {
+    // load every stored object of every Model
+    var objs []DbObject
+    for _, m := range Models {
+        keys, err := db.Keys(m.Prefix + "*")
+        if err != nil {
+            return err
+        }
+
+        for _, key := range keys {
+            // skip keys that merely share the prefix
+            id := key[len(m.Prefix):]
+            if strings.Contains(id, ":") {
+                continue
+            }
+
+            obj, err := m.Load(db, id)
+            if err != nil {
+                return err
+            }
+            objs = append(objs, obj)
+        }
+    }
+
+    // find the old indexes
+    old, err := db.Keys("idx:*")
+    if err != nil {
+        return err
+    }
+    searched, err := db.Keys("search:*")
+    if err != nil {
+        return err
+    }
+    old = append(old, searched...)
+
+    // swap them for the new ones
+    pipe := godis.NewPipeClientFromClient(db)
+    if err := pipe.Multi(); err != nil {
+        return err
+    }
+    if len(old) > 0 {
+        pipe.Del(old...)
+    }
+    for _, obj := range objs {
+        queueIndex(pipe, obj)
+        queueSearch(pipe, obj)
+    }
+
+    replies, err := pipe.Exec()
+    if err != nil {
+        return err
+    }
+    for _, r := range replies {
+        if r.Err != nil {
+            return r.Err
+        }
+    }
+
+    return nil
+}
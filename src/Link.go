@@ -0,0 +1,241 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "sort"          // for reading links in a stable order
+    "strconv"
+)
+
+// Link is a hypermedia link from a response to a related resource, telling
+// the client where it can go next and how.
+type Link struct {
+    // Rel is the relation of the linked resource to the response, e.g.
+    // "self", "collection", "edit", "next", or "prev".
+    Rel string `json:"-" xml:"rel,attr"`
+
+    // Href is the URI of the linked resource, or a URI template if
+    // Templated is set.
+    Href string `json:"href" xml:"href,attr"`
+
+    // Method is the HTTP method to use on the linked resource, if not GET.
+    Method string `json:"method,omitempty" xml:"method,attr,omitempty"`
+
+    // Templated is set if Href is a URI template, e.g.
+    // "/v1.0/providers/{id}", whose parameters the client fills in.
+    Templated bool `json:"templated,omitempty" xml:"templated,attr,omitempty"`
+}
+
+// Links are the hypermedia links of a response. In JSON they are written in
+// the style of HAL, as an object of links by relation, several links with the
+// same relation forming an array:
+//
+//     "_links": {
+//         "self": {"href": "/v1.0/providers/1001"},
+//         "edit": [{"href": "/v1.0/providers/1001", "method": "PUT"}, ...]
+//     }
+//
+// In XML each is a <link> element with the relation as its rel attribute.
+type Links []Link
+
+// MarshalJSON writes the Links as an object of links by relation.
+func (links Links) MarshalJSON () ([]byte, error) {
+    byRel := make(map[string][]Link)
+    for _, l := range links {
+        byRel[l.Rel] = append(byRel[l.Rel], l)
+    }
+
+    obj := make(map[string]interface{})
+    for rel, ls := range byRel {
+        if len(ls) == 1 {
+            obj[rel] = ls[0]
+        } else {
+            obj[rel] = ls
+        }
+    }
+
+    return json.Marshal(obj)
+}
+
+// UnmarshalJSON reads Links written by MarshalJSON, so that clients written in
+// Go can follow them. The "self" link comes first, the others follow sorted by
+// relation.
+func (links *Links) UnmarshalJSON (data []byte) error {
+    var byRel map[string]json.RawMessage
+    if err := json.Unmarshal(data, &byRel); err != nil {
+        return err
+    }
+
+    rels := make([]string, 0, len(byRel))
+    for rel := range byRel {
+        if rel != "self" {
+            rels = append(rels, rel)
+        }
+    }
+    sort.Strings(rels)
+    if _, ok := byRel["self"]; ok {
+        rels = append([]string{"self"}, rels...)
+    }
+
+    *links = nil
+    for _, rel := range rels {
+        // a relation holds a single link or an array of them
+        var ls []Link
+        if err := json.Unmarshal(byRel[rel], &ls); err != nil {
+            var l Link
+            if err := json.Unmarshal(byRel[rel], &l); err != nil {
+                return err
+            }
+            ls = []Link{l}
+        }
+
+        for _, l := range ls {
+            l.Rel = rel
+            *links = append(*links, l)
+        }
+    }
+
+    return nil
+}
+
+// Route is a route just registered with a Server or Group, which may be named
+// so that links to it can be built with WebContext.Link.
+type Route struct {
+    // srv is the Server the route is registered with.
+    srv *Server
+
+    // route is the route as registered, including the prefix of its Group.
+    route string
+}
+
+// Name gives the route a name, such as "provider", by which links to it are
+// built. Naming another route the same replaces it.
+func (r Route) Name (name string) {
+    if r.srv.names == nil {
+        r.srv.names = make(map[string]string)
+    }
+    r.srv.names[name] = r.route
+}
+
+// Href returns the URI of the route with the given name, its parameters
+// replaced in order by params.
+func (srv *Server) Href (name string, params ...string) (string, error) {
+    return srv.expand(name, false, params)
+}
+
+// Template returns the URI template of the route with the given name, e.g.
+// "/v1.0/providers/{id}" for the route "/v1.0/providers/{id:int}".
+func (srv *Server) Template (name string) (string, error) {
+    return srv.expand(name, true, nil)
+}
+
+// expand builds the URI of the named route from its segments, using params
+// for its parameters or, if template is set, their names.
+func (srv *Server) expand (name string, template bool, params []string) (string, error) {
+    route, ok := srv.names[name]
+    if ! ok {
+        return "", errors.New("no route named " + name)
+    }
+
+    segments, names, err := parseRoute(route)
+    if err != nil {
+        return "", err
+    }
+    if ! template && len(params) != len(names) {
+        return "", errors.New("route " + name + " takes " + strconv.Itoa(len(names)) + " parameters")
+    }
+
+    uri := ""
+    i := 0
+    for _, s := range segments {
+        switch {
+        case s.kind == staticSegment:
+            uri += "/" + s.value
+        case template:
+            uri += "/{" + names[i] + "}"
+            i++
+        default:
+            uri += "/" + params[i]
+            i++
+        }
+    }
+
+    return uri, nil
+}
+
+// Href returns the URI of the route with the given name, its parameters
+// replaced in order by params. As the names are fixed when the routes are
+// registered, an unknown name is a bug and panics, ending the request with a
+// 500 error.
+func (ctx *WebContext) Href (name string, params ...string) string {
+    uri, err := ctx.server.Href(name, params...)
+    if err != nil {
+        panic(err)
+    }
+
+    return uri
+}
+
+// ObjectHref returns the URI of obj, built from the route it names. Like
+// WebContext.Href, it panics if there is no such route.
+func (srv *Server) ObjectHref (obj DbObject) string {
+    name, params := obj.Route()
+    uri, err := srv.Href(name, params...)
+    if err != nil {
+        panic(err)
+    }
+
+    return uri
+}
+
+// ObjectHref returns the URI of obj, as Server.ObjectHref does.
+func (ctx *WebContext) ObjectHref (obj DbObject) string {
+    return ctx.server.ObjectHref(obj)
+}
+
+// Link returns a link with the given relation to the route with the given
+// name, to be used with method. GET, the default, is left out of the link.
+func (ctx *WebContext) Link (rel string, method string, name string, params ...string) Link {
+    l := Link{Rel: rel, Href: ctx.Href(name, params...)}
+    if method != "GET" {
+        l.Method = method
+    }
+
+    return l
+}
+
+// TemplateLink returns a link with the given relation to the URI template of
+// the route with the given name, as Link does.
+func (ctx *WebContext) TemplateLink (rel string, method string, name string) Link {
+    href, err := ctx.server.Template(name)
+    if err != nil {
+        panic(err)
+    }
+
+    l := Link{Rel: rel, Href: href, Templated: true}
+    if method != "GET" {
+        l.Method = method
+    }
+
+    return l
+}
+
+// ObjectLinks returns the links of a response holding a single object: to
+// itself, through the route named item, to the collection it belongs to,
+// through the route named collection, and to the ways it can be edited. The
+// params are those of item; the collection takes all but the last, the ID of
+// the object, if there is one.
+func (ctx *WebContext) ObjectLinks (item string, collection string, params ...string) Links {
+    parents := params
+    if len(parents) > 0 {
+        parents = parents[:len(parents) - 1]
+    }
+
+    return Links{
+        ctx.Link("self", "GET", item, params...),
+        ctx.Link("collection", "GET", collection, parents...),
+        ctx.Link("edit", "PUT", item, params...),
+        ctx.Link("edit", "PATCH", item, params...),
+        ctx.Link("delete", "DELETE", item, params...),
+    }
+}
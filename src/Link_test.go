@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"gospec"
+	. "gospec"
+	"strings"
+)
+
+// LinkSpec specifies how links are built from named routes and represented.
+func LinkSpec(c gospec.Context) {
+	srv := NewServer()
+	v1 := srv.Group("/v1.0")
+	v1.Get("/users/{uid:int}/texts", func(ctx *WebContext) {}).Name("texts")
+	v1.Get("/users/{uid:int}/texts/{tid:int}", func(ctx *WebContext) {
+		msg := MessageObject{Msg: "success", Links: ctx.ObjectLinks("text", "texts", ctx.Param("uid"), ctx.Param("tid"))}
+		msg.Links = append(msg.Links, ctx.TemplateLink("sibling", "GET", "text"))
+		ctx.Render(msg)
+	}).Name("text")
+	v1.Get("/about", func(ctx *WebContext) {
+		ctx.Render(MessageObject{Msg: "success", Links: ctx.ObjectLinks("about", "about")})
+	}).Name("about")
+
+	c.Specify("builds URIs from named routes", func() {
+		uri, err := srv.Href("text", "12", "34")
+		c.Expect(err, IsNil)
+		c.Expect(uri, Equals, "/v1.0/users/12/texts/34")
+
+		uri, err = srv.Template("text")
+		c.Expect(err, IsNil)
+		c.Expect(uri, Equals, "/v1.0/users/{uid}/texts/{tid}")
+	})
+
+	c.Specify("refuses unknown names and missing parameters", func() {
+		_, err := srv.Href("texts")
+		c.Expect(err, Not(IsNil))
+		_, err = srv.Href("notes", "12")
+		c.Expect(err, Not(IsNil))
+	})
+
+	c.Specify("writes links by relation in JSON", func() {
+		var got struct {
+			Links map[string]json.RawMessage `json:"_links"`
+		}
+		response := serve(&srv, "GET", "/v1.0/users/12/texts/34")
+		c.Expect(json.Unmarshal(response.Body.Bytes(), &got), IsNil)
+
+		var self Link
+		json.Unmarshal(got.Links["self"], &self)
+		c.Expect(self.Href, Equals, "/v1.0/users/12/texts/34")
+		c.Expect(self.Method, Equals, "")
+
+		var collection Link
+		json.Unmarshal(got.Links["collection"], &collection)
+		c.Expect(collection.Href, Equals, "/v1.0/users/12/texts")
+
+		var edit []Link
+		json.Unmarshal(got.Links["edit"], &edit)
+		c.Expect(len(edit), Equals, 2)
+		c.Expect(edit[0].Method, Equals, "PUT")
+		c.Expect(edit[1].Method, Equals, "PATCH")
+
+		var sibling Link
+		json.Unmarshal(got.Links["sibling"], &sibling)
+		c.Expect(sibling.Templated, IsTrue)
+	})
+
+	c.Specify("reads back the links it writes in JSON", func() {
+		var msg MessageObject
+		response := serve(&srv, "GET", "/v1.0/users/12/texts/34")
+		c.Expect(json.Unmarshal(response.Body.Bytes(), &msg), IsNil)
+		c.Expect(len(msg.Links), Equals, 6)
+		c.Expect(msg.Links[0].Rel, Equals, "self")
+		c.Expect(msg.Links[0].Href, Equals, "/v1.0/users/12/texts/34")
+		c.Expect(msg.Links[2].Rel, Equals, "edit")
+		c.Expect(msg.Links[3].Method, Equals, "PATCH")
+	})
+
+	c.Specify("links an object whose route has no parameters", func() {
+		var msg MessageObject
+		response := serve(&srv, "GET", "/v1.0/about")
+		c.Expect(response.Code, Equals, 200)
+		c.Expect(json.Unmarshal(response.Body.Bytes(), &msg), IsNil)
+		c.Expect(msg.Links[0].Href, Equals, "/v1.0/about")
+		c.Expect(msg.Links[1].Rel, Equals, "collection")
+		c.Expect(msg.Links[1].Href, Equals, "/v1.0/about")
+	})
+
+	c.Specify("writes links as elements in XML", func() {
+		var got struct {
+			Links []Link `xml:"links>link"`
+		}
+		response := serve(&srv, "GET", "/v1.0/users/12/texts/34?format=xml")
+		c.Expect(xml.Unmarshal(response.Body.Bytes(), &got), IsNil)
+		c.Expect(len(got.Links), Equals, 6)
+		c.Expect(got.Links[0].Rel, Equals, "self")
+		c.Expect(strings.HasSuffix(got.Links[0].Href, "/texts/34"), IsTrue)
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+    "log"
+    "strings"
+    "time"          // for timing requests
+)
+
+// LogRequests is Middleware that logs each request as it arrives.
+func LogRequests (ctx *WebContext, next func()) {
+    request := ctx.Request
+    if len(request.URL.RawQuery) == 0 {
+        log.Println(ctx.RequestId + " " + request.Method + " " + request.URL.Path)
+    } else {
+        log.Println(ctx.RequestId + " " + request.Method + " " + request.URL.Path + "?" + request.URL.RawQuery)
+    }
+
+    next()
+}
+
+// LogResponses is a Hook that logs the status of each response and how long
+// the request took.
+func LogResponses (ctx *WebContext) {
+    log.Printf("%s %d %s", ctx.RequestId, ctx.Status(), time.Now().Sub(ctx.started))
+}
+
+// Cors returns Middleware that allows browsers on the given origin ("*" for
+// any) to use the API, including the headers needed to sign requests.
+func Cors (origin string) Middleware {
+    return func(ctx *WebContext, next func()) {
+        ctx.Header.Set("Access-Control-Allow-Origin", origin)
+        ctx.Header.Set("Access-Control-Allow-Methods", strings.Join([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}, ", "))
+        ctx.Header.Set("Access-Control-Allow-Headers", strings.Join([]string{"Authorization", "Content-Type", "Date"}, ", "))
+        ctx.Header.Set("Access-Control-Expose-Headers", strings.Join([]string{"Location", "WWW-Authenticate", "X-Request-Id"}, ", "))
+        next()
+    }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "io"
+    "mime"          // for parsing media ranges
+    "net/http"
+    "strconv"       // for parsing q-values
+    "strings"
+)
+
+// Encoder writes the representation of a message or object in some media type
+// to w, indented for people to read if pretty is true.
+type Encoder func(w io.Writer, v interface{}, pretty bool) error
+
+// encoding is an Encoder registered for a media type.
+type encoding struct {
+    // mediaType is the media type produced, e.g. "application/json".
+    mediaType string
+
+    // encode produces the representation.
+    encode Encoder
+}
+
+// encodings are the registered Encoders, in order of preference.
+var encodings []encoding
+
+// init registers the JSON Encoder, which is used when the client has no
+// preference, and the XML Encoder.
+func init() {
+    RegisterEncoder("application/json", EncodeJson)
+    RegisterEncoder("application/xml", EncodeXml)
+    RegisterEncoder("text/xml", EncodeXml)
+}
+
+// RegisterEncoder makes responses available in the given media type, encoded
+// by encode. When a client accepts several media types equally, the one
+// registered first is used.
+func RegisterEncoder (mediaType string, encode Encoder) {
+    encodings = append(encodings, encoding{strings.ToLower(mediaType), encode})
+}
+
+// SupportedTypes returns the media types responses are available in, in order
+// of preference.
+func SupportedTypes () []string {
+    types := make([]string, len(encodings))
+    for i, e := range encodings {
+        types[i] = e.mediaType
+    }
+
+    return types
+}
+
+// acceptRange is a media range of an Accept header, e.g. "text/*;q=0.5".
+type acceptRange struct {
+    // mediaType is the range, which may be "*/*" or end in "/*".
+    mediaType string
+
+    // q is the relative quality factor, between 0 and 1.
+    q float64
+}
+
+// parseAccept parses the media ranges of an Accept header. Malformed ranges
+// are ignored.
+func parseAccept (accept string) []acceptRange {
+    var ranges []acceptRange
+    for _, part := range strings.Split(accept, ",") {
+        mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+        if err != nil || ! strings.Contains(mediaType, "/") {
+            continue
+        }
+
+        q := 1.0
+        if value, ok := params["q"]; ok {
+            q, err = strconv.ParseFloat(value, 64)
+            if err != nil || q < 0 || q > 1 {
+                continue
+            }
+        }
+
+        ranges = append(ranges, acceptRange{mediaType, q})
+    }
+
+    return ranges
+}
+
+// quality returns the q-value the ranges give to mediaType, taken from the
+// most specific range matching it, or 0 if none does.
+func quality (ranges []acceptRange, mediaType string) float64 {
+    q, specificity := 0.0, -1
+    for _, r := range ranges {
+        s := -1
+        switch {
+        case r.mediaType == mediaType:
+            s = 2
+        case r.mediaType == "*/*":
+            s = 0
+        case strings.HasSuffix(r.mediaType, "/*") &&
+            strings.HasPrefix(mediaType, r.mediaType[:len(r.mediaType) - 1]):
+            s = 1
+        }
+        if s > specificity {
+            q, specificity = r.q, s
+        }
+    }
+
+    return q
+}
+
+// negotiateRequest chooses the encoding for the response to request: the one
+// named by its format query parameter, e.g. "?format=xml", if any, and
+// otherwise the one preferred by its Accept header.
+func negotiateRequest (request *http.Request) (encoding, bool) {
+    format := request.URL.Query().Get("format")
+    if format == "" {
+        return negotiate(request.Header.Get("Accept"))
+    }
+
+    // formats are named by the subtype of their media type
+    for _, e := range encodings {
+        if e.mediaType[strings.Index(e.mediaType, "/") + 1:] == strings.ToLower(format) {
+            return e, true
+        }
+    }
+
+    return encoding{}, false
+}
+
+// prettyRequest reports whether the response to request should be indented:
+// as its pretty query parameter says, e.g. "?pretty=true", if any, and
+// otherwise as PrettyOutput does.
+func prettyRequest (request *http.Request) bool {
+    if pretty, err := strconv.ParseBool(request.URL.Query().Get("pretty")); err == nil {
+        return pretty
+    }
+
+    return PrettyOutput()
+}
+
+// negotiate chooses the encoding preferred by a client sending the given
+// Accept header. It fails if the client accepts none of the encodings.
+func negotiate (accept string) (encoding, bool) {
+    // a client without preference accepts anything
+    if strings.TrimSpace(accept) == "" {
+        return encodings[0], true
+    }
+
+    ranges := parseAccept(accept)
+    best, bestQ := encoding{}, 0.0
+    for _, e := range encodings {
+        if q := quality(ranges, e.mediaType); q > bestQ {
+            best, bestQ = e, q
+        }
+    }
+
+    return best, bestQ > 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"gospec"
+	. "gospec"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+)
+
+// NegotiateSpec specifies how the representation of responses is chosen from
+// the Accept header.
+func NegotiateSpec(c gospec.Context) {
+	// offer plain text as well as JSON for the duration of the spec
+	saved := encodings
+	defer func() { encodings = saved }()
+	RegisterEncoder("text/plain", func(w io.Writer, v interface{}, pretty bool) error {
+		_, err := io.WriteString(w, "plain")
+		return err
+	})
+
+	srv := NewServer()
+	srv.Get("/providers", func(ctx *WebContext) {
+		ctx.Render(MessageSuccess{Msg: "success"})
+	})
+	accept := func(value string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest("GET", "/providers", nil)
+		if value != "" {
+			request.Header.Set("Accept", value)
+		}
+		response := httptest.NewRecorder()
+		srv.ServeHTTP(response, request)
+		return response
+	}
+
+	c.Specify("uses the preferred encoder without an Accept header", func() {
+		response := accept("")
+		c.Expect(response.Code, Equals, 200)
+		c.Expect(response.Header().Get("Content-Type"), Equals, "application/json")
+		c.Expect(response.Header().Get("Vary"), Equals, "Accept")
+	})
+
+	c.Specify("honours q-values", func() {
+		c.Expect(accept("application/json;q=0.5, text/plain").Header().Get("Content-Type"), Equals, "text/plain")
+		c.Expect(accept("text/*;q=0.2, */*;q=0.4").Header().Get("Content-Type"), Equals, "application/json")
+		c.Expect(accept("*/*").Header().Get("Content-Type"), Equals, "application/json")
+	})
+
+	c.Specify("lets the most specific range set the quality", func() {
+		response := accept("text/*, text/plain;q=0")
+		c.Expect(response.Header().Get("Content-Type"), Equals, "text/xml")
+		c.Expect(response.Body.String(), Not(Equals), "plain")
+	})
+
+	c.Specify("lets the format parameter override the Accept header", func() {
+		request, _ := http.NewRequest("GET", "/providers?format=xml", nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+		srv.ServeHTTP(response, request)
+		c.Expect(response.Header().Get("Content-Type"), Equals, "application/xml")
+
+		request, _ = http.NewRequest("GET", "/providers?format=yaml", nil)
+		response = httptest.NewRecorder()
+		srv.ServeHTTP(response, request)
+		c.Expect(response.Code, Equals, 406)
+	})
+
+	c.Specify("answers 406 listing the supported types", func() {
+		response := accept("image/png, application/json;q=0")
+		c.Expect(response.Code, Equals, 406)
+		c.Expect(response.Header().Get("Content-Type"), Equals, "application/json")
+		c.Expect(strings.Contains(response.Body.String(), "application/json, application/xml, text/xml, text/plain"), IsTrue)
+	})
+}
+
+// PrettySpec specifies when responses are indented and how encoding errors
+// are reported.
+func PrettySpec(c gospec.Context) {
+	saved := os.Getenv("CITEPLASM_PRETTY")
+	defer os.Setenv("CITEPLASM_PRETTY", saved)
+
+	srv := NewServer()
+	srv.Get("/providers", func(ctx *WebContext) {
+		ctx.Render(MessageSuccess{Msg: "success", Results: []Resource{}})
+	})
+	srv.Get("/broken", func(ctx *WebContext) {
+		ctx.Render(MessageObject{Msg: "success", Result: make(chan int)})
+	})
+
+	c.Specify("compacts responses by default", func() {
+		os.Setenv("CITEPLASM_PRETTY", "")
+		body := serve(&srv, "GET", "/providers").Body.String()
+		c.Expect(body, Equals, `{"msg":"success","results":[],"total":0}`+"\n")
+	})
+
+	c.Specify("indents responses when configured to", func() {
+		os.Setenv("CITEPLASM_PRETTY", "true")
+		body := serve(&srv, "GET", "/providers").Body.String()
+		c.Expect(strings.Contains(body, "\n    \"msg\""), IsTrue)
+	})
+
+	c.Specify("lets the pretty parameter override the configuration", func() {
+		os.Setenv("CITEPLASM_PRETTY", "true")
+		body := serve(&srv, "GET", "/providers?pretty=false").Body.String()
+		c.Expect(strings.Contains(body, "\n "), IsFalse)
+
+		os.Setenv("CITEPLASM_PRETTY", "false")
+		body = serve(&srv, "GET", "/providers?pretty=true").Body.String()
+		c.Expect(strings.Contains(body, "\n    \"msg\""), IsTrue)
+	})
+
+	c.Specify("answers 500 when the response cannot be encoded", func() {
+		response := serve(&srv, "GET", "/broken")
+		c.Expect(response.Code, Equals, 500)
+		c.Expect(strings.Contains(response.Body.String(), "could not be encoded"), IsTrue)
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+    "log"
+    "net/url"       // for building the links to other pages
+    "os"            // for env vars
+    "strconv"
+)
+
+// DefaultPageSize is the number of results a collection endpoint returns when
+// the client does not ask for a particular number.
+const DefaultPageSize = 10
+
+// defaultMaxPageSize is the largest number of results a collection endpoint
+// returns at once unless CITEPLASM_MAX_PAGE_SIZE says otherwise.
+const defaultMaxPageSize = 100
+
+// maxInt is the largest value of an int.
+const maxInt = int(^uint(0) >> 1)
+
+// Page represents the slice of a collection requested by a client through the
+// "offset" and "limit" query parameters.
+type Page struct {
+    // Offset is the number of results to skip.
+    Offset int
+
+    // Limit is the largest number of results to return.
+    Limit int
+}
+
+// MaxPageSize returns the largest number of results a collection endpoint
+// returns at once. It can be set through the CITEPLASM_MAX_PAGE_SIZE
+// environment variable.
+func MaxPageSize() int {
+    max := os.Getenv("CITEPLASM_MAX_PAGE_SIZE")
+    if max == "" {
+        return defaultMaxPageSize
+    }
+
+    // the default still bounds the size of a page, which is what the setting
+    // is for
+    n, err := strconv.Atoi(max)
+    if err != nil || n < 1 {
+        log.Printf("Environment variable CITEPLASM_MAX_PAGE_SIZE must be a positive integer; using %d.", defaultMaxPageSize)
+        return defaultMaxPageSize
+    }
+
+    return n
+}
+
+// ParsePage reads the Page requested through the "offset" and "limit" query
+// parameters. A limit larger than MaxPageSize is reduced to it. Malformed
+// parameters, and offsets so large that the end of the page would overflow an
+// int, are reported as a ValidationError.
+func ParsePage(ctx *WebContext) (Page, error) {
+    page := Page{0, DefaultPageSize}
+    query := ctx.Request.URL.Query()
+
+    if offset := query.Get("offset"); offset != "" {
+        n, err := strconv.Atoi(offset)
+        if err != nil || n < 0 {
+            return page, ValidationError("The offset must be a non-negative integer.")
+        }
+        page.Offset = n
+    }
+
+    if limit := query.Get("limit"); limit != "" {
+        n, err := strconv.Atoi(limit)
+        if err != nil || n < 1 {
+            return page, ValidationError("The limit must be a positive integer.")
+        }
+        page.Limit = n
+    }
+
+    if max := MaxPageSize(); page.Limit > max {
+        page.Limit = max
+    }
+
+    // the end of the page must be representable, as it is computed
+    // throughout
+    if page.Offset > maxInt - page.Limit {
+        return page, ValidationError("The offset is too large.")
+    }
+
+    return page, nil
+}
+
+// NewPageMessage creates a MessageSuccess holding one Page of a collection of
+// total results, with links to the page itself and to the next and previous
+// pages, if any. The links keep the other query parameters of the request.
+func NewPageMessage(ctx *WebContext, page Page, results []Resource, total int64) MessageSuccess {
+    msg := MessageSuccess{Msg: "success", Results: results, Total: total}
+    msg.Links = Links{Link{Rel: "self", Href: pageUri(ctx.Request.URL, page.Offset, page.Limit)}}
+
+    // a client should get back an empty list rather than null
+    if msg.Results == nil {
+        msg.Results = []Resource{}
+    }
+
+    if int64(page.Offset + page.Limit) < total {
+        msg.Next = pageUri(ctx.Request.URL, page.Offset + page.Limit, page.Limit)
+        msg.Links = append(msg.Links, Link{Rel: "next", Href: msg.Next})
+    }
+
+    if page.Offset > 0 {
+        prev := page.Offset - page.Limit
+        if prev < 0 {
+            prev = 0
+        }
+        msg.Prev = pageUri(ctx.Request.URL, prev, page.Limit)
+        msg.Links = append(msg.Links, Link{Rel: "prev", Href: msg.Prev})
+    }
+
+    return msg
+}
+
+// pageUri returns the URI of the request u for the page at offset.
+func pageUri(u *url.URL, offset int, limit int) string {
+    query := u.Query()
+    query.Set("offset", strconv.Itoa(offset))
+    query.Set("limit", strconv.Itoa(limit))
+
+    return u.Path + "?" + query.Encode()
+}
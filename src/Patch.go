@@ -0,0 +1,380 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "io/ioutil"     // for reading request bodies
+    "mime"          // for parsing the Content-Type
+    "reflect"       // for the JSON Patch "test" operation
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// The media types accepted in the body of a PATCH request.
+const (
+    // MergePatchType is a JSON Merge Patch (RFC 7396): a partial document
+    // whose members replace those of the target, with null removing them.
+    MergePatchType = "application/merge-patch+json"
+
+    // JsonPatchType is a JSON Patch (RFC 6902): an array of operations
+    // applied to the target in order.
+    JsonPatchType = "application/json-patch+json"
+)
+
+// ErrUnsupportedPatch is returned when a patch is neither a JSON Merge Patch
+// nor a JSON Patch.
+var ErrUnsupportedPatch = errors.New("unsupported patch media type")
+
+// PatchError is returned when a well-formed patch cannot be applied, e.g.
+// because an operation is unknown, a path does not exist, a "test" operation
+// fails, or the patched document is not a valid object. Its text is suitable
+// for returning to the client.
+type PatchError string
+
+// Error returns the explanation of the PatchError.
+func (e PatchError) Error() string {
+    return string(e)
+}
+
+// ApplyPatch applies the patch in body, of the given media type, to the JSON
+// representation of old and decodes the result into patched, which should
+// point to a zero value of the same type as old. Malformed patches are
+// reported with the error of the JSON decoder, and patches adding members that
+// patched does not have with a PatchError.
+func ApplyPatch (mediaType string, body []byte, old interface{}, patched interface{}) error {
+    if mediaType != MergePatchType && mediaType != JsonPatchType {
+        return ErrUnsupportedPatch
+    }
+
+    // patches operate on the generic JSON document
+    j, err := json.Marshal(old)
+    if err != nil {
+        return err
+    }
+    var doc interface{}
+    if err := json.Unmarshal(j, &doc); err != nil {
+        return err
+    }
+
+    if mediaType == MergePatchType {
+        var patch interface{}
+        if err := json.Unmarshal(body, &patch); err != nil {
+            return err
+        }
+        doc = mergePatch(doc, patch)
+    } else {
+        var ops []map[string]interface{}
+        if err := json.Unmarshal(body, &ops); err != nil {
+            return err
+        }
+        for i, op := range ops {
+            doc, err = applyOperation(doc, op)
+            if err != nil {
+                return PatchError("Operation " + strconv.Itoa(i) + " cannot be applied: " + err.Error())
+            }
+        }
+    }
+
+    // the patched document must still describe the same kind of object, as
+    // members the object lacks would otherwise be silently dropped
+    members, ok := doc.(map[string]interface{})
+    if ! ok {
+        return PatchError("The patched document must be an object.")
+    }
+    fields := JsonFields(patched)
+    for name := range members {
+        if ! containsString(fields, name) {
+            sort.Strings(fields)
+            return PatchError("Unknown field \"" + name + "\"; the fields are " + strings.Join(fields, ", ") + ".")
+        }
+    }
+    j, err = json.Marshal(doc)
+    if err != nil {
+        return err
+    }
+    if err := json.Unmarshal(j, patched); err != nil {
+        return PatchError("The patched document is not valid: " + err.Error())
+    }
+
+    return nil
+}
+
+// ReadPatch applies the patch in the body of the request to old, decoding the
+// result into patched as ApplyPatch does. If the patch cannot be applied, the
+// matching error is sent to the client and false is returned.
+func ReadPatch (ctx *WebContext, old interface{}, patched interface{}) bool {
+    mediaType, _, err := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type"))
+    if err != nil {
+        mediaType = ""
+    }
+
+    body, err := ioutil.ReadAll(ctx.Request.Body)
+    if err != nil {
+        ctx.Error(400, "The request body could not be read.")
+        return false
+    }
+
+    err = ApplyPatch(mediaType, body, old, patched)
+    if err == nil {
+        return true
+    }
+
+    if err == ErrUnsupportedPatch {
+        ctx.Header.Set("Accept-Patch", MergePatchType + ", " + JsonPatchType)
+        ctx.Error(415, "The request body must be a " + MergePatchType + " or " + JsonPatchType + " document.")
+    } else if perr, ok := err.(PatchError); ok {
+        ctx.Error(422, perr.Error())
+    } else {
+        ctx.Error(400, "The request body is not a valid " + mediaType + " document.")
+    }
+
+    return false
+}
+
+// mergePatch applies the JSON Merge Patch patch to target and returns the
+// result. Members of an object patch replace those of target, recursively,
+// and null members remove them; any other patch replaces target.
+func mergePatch (target interface{}, patch interface{}) interface{} {
+    p, ok := patch.(map[string]interface{})
+    if ! ok {
+        return patch
+    }
+
+    t, ok := target.(map[string]interface{})
+    if ! ok {
+        t = make(map[string]interface{})
+    }
+    for name, value := range p {
+        if value == nil {
+            delete(t, name)
+        } else {
+            t[name] = mergePatch(t[name], value)
+        }
+    }
+
+    return t
+}
+
+// applyOperation applies a single JSON Patch operation to doc and returns the
+// result.
+func applyOperation (doc interface{}, op map[string]interface{}) (interface{}, error) {
+    name, _ := op["op"].(string)
+    path, err := operationPointer(op, "path")
+    if err != nil {
+        return nil, err
+    }
+
+    switch name {
+    case "add", "replace", "test":
+        value, ok := op["value"]
+        if ! ok {
+            return nil, errors.New("the operation has no value")
+        }
+        if name == "test" {
+            current, err := pointerGet(doc, path)
+            if err != nil {
+                return nil, err
+            }
+            if ! reflect.DeepEqual(current, value) {
+                return nil, errors.New("the value at the path does not match")
+            }
+            return doc, nil
+        }
+        return pointerAdd(doc, path, value, name == "replace")
+
+    case "remove":
+        doc, _, err = pointerRemove(doc, path)
+        return doc, err
+
+    case "move", "copy":
+        from, err := operationPointer(op, "from")
+        if err != nil {
+            return nil, err
+        }
+        value, err := pointerGet(doc, from)
+        if err != nil {
+            return nil, err
+        }
+        if name == "move" {
+            // a value cannot be moved into one of its own children
+            if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+                return nil, errors.New("a value cannot be moved into itself")
+            }
+            if doc, _, err = pointerRemove(doc, from); err != nil {
+                return nil, err
+            }
+        } else {
+            // the copy must not share maps or slices with the original
+            j, _ := json.Marshal(value)
+            json.Unmarshal(j, &value)
+        }
+        return pointerAdd(doc, path, value, false)
+    }
+
+    return nil, errors.New("unknown operation \"" + name + "\"")
+}
+
+// operationPointer returns the reference tokens of the JSON Pointer in the
+// member of op with the given name.
+func operationPointer (op map[string]interface{}, member string) ([]string, error) {
+    pointer, ok := op[member].(string)
+    if ! ok {
+        return nil, errors.New("the operation has no " + member)
+    }
+
+    // the empty pointer refers to the whole document
+    if pointer == "" {
+        return []string{}, nil
+    }
+    if pointer[0] != '/' {
+        return nil, errors.New("the " + member + " \"" + pointer + "\" is not a JSON Pointer")
+    }
+
+    tokens := strings.Split(pointer[1:], "/")
+    for i, t := range tokens {
+        tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+    }
+
+    return tokens, nil
+}
+
+// arrayIndex parses the reference token of an element of an array of length
+// n.
+func arrayIndex (token string, n int) (int, error) {
+    i, err := strconv.Atoi(token)
+    if err != nil || i < 0 || i >= n || (token != "0" && token[0] == '0') {
+        return 0, errors.New("the array has no element \"" + token + "\"")
+    }
+
+    return i, nil
+}
+
+// pointerGet returns the value of doc referred to by path.
+func pointerGet (doc interface{}, path []string) (interface{}, error) {
+    for _, token := range path {
+        switch d := doc.(type) {
+        case map[string]interface{}:
+            value, ok := d[token]
+            if ! ok {
+                return nil, errors.New("the object has no member \"" + token + "\"")
+            }
+            doc = value
+        case []interface{}:
+            i, err := arrayIndex(token, len(d))
+            if err != nil {
+                return nil, err
+            }
+            doc = d[i]
+        default:
+            return nil, errors.New("the path refers into a value that is not a container")
+        }
+    }
+
+    return doc, nil
+}
+
+// pointerAdd sets the value of doc referred to by path and returns the
+// result. Unless replace is true, values are inserted into arrays rather than
+// replacing an element, and "-" appends to an array; if replace is true, the
+// value must already exist.
+func pointerAdd (doc interface{}, path []string, value interface{}, replace bool) (interface{}, error) {
+    if len(path) == 0 {
+        return value, nil
+    }
+    token := path[0]
+
+    switch d := doc.(type) {
+    case map[string]interface{}:
+        child, ok := d[token]
+        if len(path) == 1 {
+            if replace && ! ok {
+                return nil, errors.New("the object has no member \"" + token + "\"")
+            }
+            d[token] = value
+            return d, nil
+        }
+        if ! ok {
+            return nil, errors.New("the object has no member \"" + token + "\"")
+        }
+        child, err := pointerAdd(child, path[1:], value, replace)
+        if err != nil {
+            return nil, err
+        }
+        d[token] = child
+        return d, nil
+
+    case []interface{}:
+        if len(path) == 1 && ! replace {
+            if token == "-" {
+                return append(d, value), nil
+            }
+            // an element may be inserted just past the end of the array
+            i, err := arrayIndex(token, len(d) + 1)
+            if err != nil {
+                return nil, err
+            }
+            d = append(d, nil)
+            copy(d[i + 1:], d[i:])
+            d[i] = value
+            return d, nil
+        }
+        i, err := arrayIndex(token, len(d))
+        if err != nil {
+            return nil, err
+        }
+        child, err := pointerAdd(d[i], path[1:], value, replace)
+        if err != nil {
+            return nil, err
+        }
+        d[i] = child
+        return d, nil
+    }
+
+    return nil, errors.New("the path refers into a value that is not a container")
+}
+
+// pointerRemove removes the value of doc referred to by path, returning the
+// result and the removed value.
+func pointerRemove (doc interface{}, path []string) (interface{}, interface{}, error) {
+    if len(path) == 0 {
+        return nil, nil, errors.New("the whole document cannot be removed")
+    }
+    token := path[0]
+
+    switch d := doc.(type) {
+    case map[string]interface{}:
+        child, ok := d[token]
+        if ! ok {
+            return nil, nil, errors.New("the object has no member \"" + token + "\"")
+        }
+        if len(path) == 1 {
+            delete(d, token)
+            return d, child, nil
+        }
+        child, removed, err := pointerRemove(child, path[1:])
+        if err != nil {
+            return nil, nil, err
+        }
+        d[token] = child
+        return d, removed, nil
+
+    case []interface{}:
+        i, err := arrayIndex(token, len(d))
+        if err != nil {
+            return nil, nil, err
+        }
+        if len(path) == 1 {
+            removed := d[i]
+            return append(d[:i], d[i + 1:]...), removed, nil
+        }
+        child, removed, err := pointerRemove(d[i], path[1:])
+        if err != nil {
+            return nil, nil, err
+        }
+        d[i] = child
+        return d, removed, nil
+    }
+
+    return nil, nil, errors.New("the path refers into a value that is not a container")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"gospec"
+	. "gospec"
+)
+
+// patchSample is the target of the patches in PatchSpec.
+type patchSample struct {
+	Title   string   `json:"title"`
+	Authors []string `json:"authors"`
+	Url     string   `json:"url,omitempty"`
+}
+
+// PatchSpec specifies how JSON Merge Patches and JSON Patches are applied.
+func PatchSpec(c gospec.Context) {
+	old := patchSample{"SICP", []string{"Harold Abelson", "Gerald Jay Sussman"}, "http://mitpress.mit.edu/sicp/"}
+
+	c.Specify("merges a partial document, removing null members", func() {
+		var patched patchSample
+		err := ApplyPatch(MergePatchType, []byte(`{"title":"SICP, 2nd ed.","url":null}`), old, &patched)
+		c.Assume(err, IsNil)
+		c.Expect(patched.Title, Equals, "SICP, 2nd ed.")
+		c.Expect(patched.Url, Equals, "")
+		c.Expect(patched.Authors, ContainsExactly, Values("Harold Abelson", "Gerald Jay Sussman"))
+	})
+
+	c.Specify("applies operations in order", func() {
+		var patched patchSample
+		err := ApplyPatch(JsonPatchType, []byte(`[
+			{"op":"test","path":"/title","value":"SICP"},
+			{"op":"replace","path":"/title","value":"Structure and Interpretation"},
+			{"op":"add","path":"/authors/-","value":"Julie Sussman"},
+			{"op":"remove","path":"/authors/0"},
+			{"op":"copy","from":"/authors/0","path":"/authors/0"},
+			{"op":"move","from":"/url","path":"/title"}
+		]`), old, &patched)
+		c.Assume(err, IsNil)
+		c.Expect(patched.Title, Equals, "http://mitpress.mit.edu/sicp/")
+		c.Expect(patched.Url, Equals, "")
+		c.Expect(patched.Authors, ContainsExactly, Values("Gerald Jay Sussman", "Gerald Jay Sussman", "Julie Sussman"))
+	})
+
+	c.Specify("leaves the original untouched", func() {
+		var patched patchSample
+		ApplyPatch(JsonPatchType, []byte(`[{"op":"remove","path":"/authors/0"}]`), old, &patched)
+		c.Expect(len(old.Authors), Equals, 2)
+	})
+
+	c.Specify("rejects operations that cannot be applied", func() {
+		var patched patchSample
+		for _, body := range []string{
+			`[{"op":"frobnicate","path":"/title"}]`,
+			`[{"op":"replace","path":"/publisher","value":"MIT"}]`,
+			`[{"op":"remove","path":"/authors/2"}]`,
+			`[{"op":"add","path":"title","value":"SICP"}]`,
+			`[{"op":"add","path":"/title"}]`,
+			`[{"op":"test","path":"/title","value":"HtDP"}]`,
+			`[{"op":"replace","path":"/title","value":42}]`,
+			`[{"op":"replace","path":"","value":[]}]`,
+		} {
+			err := ApplyPatch(JsonPatchType, []byte(body), old, &patched)
+			_, ok := err.(PatchError)
+			c.Expect(ok, IsTrue)
+		}
+	})
+
+	c.Specify("rejects members the target does not have", func() {
+		var patched patchSample
+		err := ApplyPatch(MergePatchType, []byte(`{"publisher":"MIT Press"}`), old, &patched)
+		_, ok := err.(PatchError)
+		c.Expect(ok, IsTrue)
+
+		err = ApplyPatch(JsonPatchType, []byte(`[{"op":"add","path":"/publisher","value":"MIT Press"}]`), old, &patched)
+		_, ok = err.(PatchError)
+		c.Expect(ok, IsTrue)
+
+		// a member left out of the target's JSON may still be added
+		var trimmed patchSample
+		err = ApplyPatch(MergePatchType, []byte(`{"url":"http://example.com/"}`), patchSample{Title: "SICP"}, &trimmed)
+		c.Expect(err, IsNil)
+		c.Expect(trimmed.Url, Equals, "http://example.com/")
+	})
+
+	c.Specify("reports malformed and unsupported patches", func() {
+		var patched patchSample
+		err := ApplyPatch(JsonPatchType, []byte(`{"op":`), old, &patched)
+		c.Expect(err, Not(IsNil))
+		_, ok := err.(PatchError)
+		c.Expect(ok, IsFalse)
+
+		err = ApplyPatch("application/json", []byte(`{"title":"HtDP"}`), old, &patched)
+		c.Expect(err, Equals, ErrUnsupportedPatch)
+	})
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-    "log"
     "godis"
-    "strings"
     "strconv"
 )
 
@@ -11,32 +9,43 @@ import (
 type Provider struct {
 
     // Identifier is the unique ID of the resource.
-    Identifier string `json:"-"`
+    Identifier string `json:"-" xml:"-" db:"-"`
 
     // Name is a friendly representation of the resource.
-    Name string `json:"name"`
+    Name string `json:"name" xml:"name" search:"3"`
 
     // Icon is an URL to a 24x24 icon representative of the Provider.
-    Icon string `json:"icon"`
+    Icon string `json:"icon" xml:"icon" db:",omitempty"`
 
     // Logo is an URL to a larger image representative of the Provider.
-    Logo string `json:"logo"`
+    Logo string `json:"logo" xml:"logo" db:",omitempty"`
 
     // Description is a long-form explanation of the Provider.
-    Description string `json:"descr"`
+    Description string `json:"descr" xml:"descr" db:",omitempty" search:"1"`
 
     // db is an internal pointer to the database connection.
-    db *godis.Client `json:"-"`
+    db *godis.Client `json:"-" xml:"-"`
+}
+
+// init registers Providers as a Model.
+func init() {
+    RegisterModel("prov:", func(db *godis.Client, id string) (DbObject, error) {
+        p, err := LoadProvider(db, id)
+        if err != nil {
+            return nil, err
+        }
+        return p, nil
+    })
 }
 
 // NewProvider creates a new Provider.
-func NewProvider( db *godis.Client, name string ) *Provider {
+func NewProvider( db *godis.Client, name string ) (*Provider, error) {
     var p Provider
 
     // get the next available provider ID
     i64, err := db.Incr("nxProvId")
     if err != nil {
-        log.Fatal("Could not INCR nxProvId!")
+        return nil, err
     }
 
     // build the Provider
@@ -45,7 +54,22 @@ func NewProvider( db *godis.Client, name string ) *Provider {
     p.db = db
 
     // return a pointer to the Provider
-    return &p
+    return &p, nil
+}
+
+// LoadProvider fetches the Provider with the given ID from the database. If no
+// such Provider exists, ErrNotFound is returned.
+func LoadProvider( db *godis.Client, id string ) (*Provider, error) {
+    var p Provider
+    p.Identifier = id
+    p.db = db
+
+    if err := LoadHash(db, p.GetKey(), &p); err != nil {
+        return nil, err
+    }
+
+    // return a pointer to the Provider
+    return &p, nil
 }
 
 // GetKey returns the database key for this Provider.
@@ -68,42 +92,52 @@ func (p *Provider) Label() string {
     return p.Name
 }
 
-// Uri returns the URI of this Provider within this API.
-func (p *Provider) Uri() string {
-    return "/providers/" + p.Identifier
+// Route returns the name of the route serving this Provider and its parameters.
+func (p *Provider) Route() (string, []string) {
+    return "provider", []string{p.Identifier}
 }
 
-// GetProviders returns an array of the first 10 Providers.
-// FIXME support different result counts
-func GetProviders (db *godis.Client) []Resource {
+// GetProviders returns one Page of the Providers selected and sorted by query,
+// along with the total number of them. The view says what more of each
+// Provider to include; their URIs are built from the routes of srv.
+func GetProviders (db *godis.Client, srv *Server, query Query, page Page, view View) ([]Resource, int64, error) {
     var providers []Resource
 
-    // fetch the Providers
-    r, err := db.Lrange("idx:Provider", 0, 10)
+    // fetch the Providers, which are stored at "prov:ID"
+    var idx Provider
+    idx.Identifier = "*"
+    entries, total, err := QueryIndex(db, "idx:Provider", idx.GetKey(), query, page)
     if err != nil {
-        log.Fatal("Could not get providers.")
+        return nil, 0, err
     }
 
-    // conver the Reply into a []string of "Id|Label" representations
-    s := r.StringArray()
-
     // loop through all results, creating resources
-    for i := 0; i < len(s); i++ {
-        // split the "Id|Label"
-        vals := strings.SplitN(s[i], "|", 2)
-        if len(vals) != 2 {
-            log.Fatalf("For some reason, the index was improperly formatted: %s", s[i])
-        }
-
+    for _, e := range entries {
         // build the Provider
         var p Provider
-        p.Identifier = vals[0]
-        p.Name = vals[1]
+        p.Identifier = e.Id
+        p.Name = e.Label
+
+        // create a resource from the provider, loading the whole provider
+        // if the view asks for more than its name
+        r := NewResource(srv, &p)
+        if ! view.Empty() {
+            full, err := LoadProvider(db, e.Id)
+            if err == ErrNotFound {
+                // deleted since the index was read
+                continue
+            } else if err != nil {
+                return nil, 0, err
+            }
+            if err := view.Apply(&r, full); err != nil {
+                return nil, 0, err
+            }
+        }
 
-        // create a resource from the provider and add it to the array to return
-        providers = append(providers, NewResource(&p))
+        // add it to the array to return
+        providers = append(providers, r)
     }
 
     // return the array of providers
-    return providers
+    return providers, total, nil
 }
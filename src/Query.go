@@ -0,0 +1,440 @@
+package main
+
+import (
+    "errors"
+    "godis"
+    "reflect"       // for finding the fields of a model
+    "sort"
+    "strconv"       // for comparing numeric fields
+    "strings"
+)
+
+// Collections can be sorted and filtered through the "sort" and "filter"
+// query parameters:
+//
+//     ?sort=name,-id          by name, then newest first
+//     ?filter=name~library    names containing "library", in any case
+//     ?filter=owner=1001      owner exactly "1001"
+//
+// Several filters, given as several "filter" parameters, must all match. The
+// fields are those of the JSON representation of the objects, plus "id".
+// Without a sort, collections are listed newest first.
+
+// idField is the name of the ID in sorts and filters.
+const idField = "id"
+
+// QueryField is a field of a model that collections can be sorted and
+// filtered by.
+type QueryField struct {
+    // Hash is the name of the field in the hash of the object, or "" for the
+    // ID, which is not stored in the hash.
+    Hash string
+
+    // Numeric is set if the field is compared as a number rather than as
+    // text.
+    Numeric bool
+
+    // List is set if the field holds a list, which can be filtered by but not
+    // sorted by.
+    List bool
+}
+
+// QueryFields returns the fields collections of obj, a struct or a pointer to
+// one, can be sorted and filtered by, by their name in the JSON
+// representation, leaving out those in except.
+func QueryFields (obj interface{}, except ...string) map[string]QueryField {
+    t := reflect.TypeOf(obj)
+    if t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+
+    fields := map[string]QueryField{idField: QueryField{Numeric: true}}
+    for i := 0; i < t.NumField(); i++ {
+        ft := t.Field(i)
+
+        // only fields that are both shown and stored can be queried
+        if ft.PkgPath != "" {
+            continue
+        }
+        name := ft.Tag.Get("json")
+        if comma := strings.Index(name, ","); comma >= 0 {
+            name = name[:comma]
+        }
+        hash, _, skip := hashTag(ft)
+        if name == "-" || skip || containsString(except, name) {
+            continue
+        }
+        if name == "" {
+            name = ft.Name
+        }
+
+        // nested structs are stored field by field, so cannot be compared
+        kind := ft.Type.Kind()
+        if kind == reflect.Struct && ft.Type != timeType {
+            continue
+        }
+
+        fields[name] = QueryField{
+            Hash:    hash,
+            Numeric: isNumericKind(kind),
+            List:    kind == reflect.Slice,
+        }
+    }
+
+    return fields
+}
+
+// isNumericKind reports whether values of kind are stored as numbers.
+func isNumericKind (kind reflect.Kind) bool {
+    switch kind {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+        reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+        reflect.Float32, reflect.Float64:
+        return true
+    }
+
+    return false
+}
+
+// SortKey is one of the keys by which a collection is sorted.
+type SortKey struct {
+    // Name is the name of the field, as in the JSON representation.
+    Name string
+
+    // Field is the field sorted by.
+    Field QueryField
+
+    // Desc is set if the collection is sorted in descending order, as with
+    // "-name".
+    Desc bool
+}
+
+// Filter is a condition the objects of a collection must meet.
+type Filter struct {
+    // Name is the name of the field, as in the JSON representation.
+    Name string
+
+    // Field is the field compared.
+    Field QueryField
+
+    // Op is the comparison: "~" if the field must contain Value, in any case,
+    // or "=" if it must equal Value.
+    Op string
+
+    // Value is the value compared with. Lists are compared in their JSON
+    // representation, e.g. `["Homer"]`.
+    Value string
+}
+
+// matches reports whether the field with the given value meets the Filter.
+func (f Filter) matches (value string) bool {
+    if f.Op == "~" {
+        return strings.Contains(strings.ToLower(value), strings.ToLower(f.Value))
+    }
+
+    return value == f.Value
+}
+
+// Query is the order and selection of a collection requested through the
+// "sort" and "filter" query parameters.
+type Query struct {
+    // Sort are the keys the collection is sorted by, the first first.
+    Sort []SortKey
+
+    // Filters are the conditions the objects must all meet.
+    Filters []Filter
+}
+
+// Empty reports whether the Query asks for the collection as it is indexed,
+// newest first.
+func (q Query) Empty () bool {
+    return len(q.Sort) == 0 && len(q.Filters) == 0
+}
+
+// ParseQuery reads the Query requested through the "sort" and "filter" query
+// parameters, allowing only the given fields; see QueryFields. Unknown fields
+// and malformed filters are reported as a ValidationError.
+func ParseQuery (ctx *WebContext, fields map[string]QueryField) (Query, error) {
+    var q Query
+    query := ctx.Request.URL.Query()
+
+    if s := query.Get("sort"); s != "" {
+        seen := make(map[string]bool)
+        for _, name := range strings.Split(s, ",") {
+            name = strings.TrimSpace(name)
+            desc := strings.HasPrefix(name, "-")
+            if desc {
+                name = name[1:]
+            }
+            if name == "" || seen[name] {
+                continue
+            }
+
+            f, ok := fields[name]
+            if ! ok {
+                return q, unknownField(name, fields)
+            }
+            if f.List {
+                return q, ValidationError("Cannot sort by the list \"" + name + "\".")
+            }
+            seen[name] = true
+            q.Sort = append(q.Sort, SortKey{name, f, desc})
+        }
+    }
+
+    for _, filter := range query["filter"] {
+        op := strings.IndexAny(filter, "~=")
+        if op < 1 {
+            return q, ValidationError("A filter must be written as field~value or field=value.")
+        }
+
+        name := strings.TrimSpace(filter[:op])
+        f, ok := fields[name]
+        if ! ok {
+            return q, unknownField(name, fields)
+        }
+        q.Filters = append(q.Filters, Filter{name, f, filter[op:op + 1], filter[op + 1:]})
+    }
+
+    return q, nil
+}
+
+// unknownField returns the ValidationError for a sort or filter by an unknown
+// field.
+func unknownField (name string, fields map[string]QueryField) error {
+    names := make([]string, 0, len(fields))
+    for n := range fields {
+        names = append(names, n)
+    }
+    sort.Strings(names)
+
+    return ValidationError("Unknown field \"" + name + "\"; the fields are " + strings.Join(names, ", ") + ".")
+}
+
+// QueryIndex returns one Page of the entries of the index at key, sorted and
+// filtered by q, along with the total number of entries that pass the
+// filters. The objects are stored in the hashes matching pattern, e.g.
+// "prov:*". An empty Query reads the index as ReadIndex does.
+//
+// A single sort without filters is done by Redis; otherwise the fields needed
+// are fetched for every entry in one SORT command and the entries sorted and
+// filtered here. That costs O(N) in the size of the whole index, not of the
+// page, both in what is sent over the connection and in memory, whatever the
+// page asked for: fine for the texts and citations of one User, but the cost
+// to watch on the larger, shared indexes of Providers and Users.
+func QueryIndex (db *godis.Client, key string, pattern string, q Query, page Page) ([]IndexEntry, int64, error) {
+    if q.Empty() {
+        return ReadIndex(db, key, page)
+    }
+
+    var ids []string
+    var total int64
+    var err error
+    if len(q.Filters) == 0 && len(q.Sort) == 1 {
+        ids, total, err = sortIndex(db, key, pattern, q.Sort[0], page)
+    } else {
+        ids, total, err = selectIndex(db, key, pattern, q, page)
+    }
+    if err != nil {
+        return nil, 0, err
+    }
+
+    entries, err := readLabels(db, key, ids)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    return entries, total, nil
+}
+
+// sortIndex returns the IDs of one Page of the entries of the index at key,
+// sorted by s, along with the total number of entries.
+func sortIndex (db *godis.Client, key string, pattern string, s SortKey, page Page) ([]string, int64, error) {
+    total, err := db.Zcard(key)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // the index is already sorted by ID
+    start, stop := page.Offset, page.Offset + page.Limit - 1
+    var r *godis.Reply
+    switch {
+    case s.Field.Hash == "" && s.Desc:
+        r, err = db.Zrevrange(key, start, stop)
+    case s.Field.Hash == "":
+        r, err = db.Zrange(key, start, stop)
+    default:
+        args := []string{"BY", pattern + "->" + s.Field.Hash}
+        if ! s.Field.Numeric {
+            args = append(args, "ALPHA")
+        }
+        if s.Desc {
+            args = append(args, "DESC")
+        }
+        args = append(args, "LIMIT", strconv.Itoa(page.Offset), strconv.Itoa(page.Limit))
+        r, err = db.Sort(key, args...)
+    }
+    if err != nil {
+        return nil, 0, err
+    }
+
+    return r.StringArray(), total, nil
+}
+
+// selectIndex returns the IDs of one Page of the entries of the index at key
+// that pass the filters of q, sorted by q, along with the total number of
+// entries that pass the filters. It reads every entry of the index; see
+// QueryIndex.
+func selectIndex (db *godis.Client, key string, pattern string, q Query, page Page) ([]string, int64, error) {
+    // fetch every ID along with the fields that are compared
+    var hashes []string
+    args := []string{"BY", "nosort", "GET", "#"}
+    for _, s := range q.Sort {
+        hashes = appendHash(hashes, s.Field)
+    }
+    for _, f := range q.Filters {
+        hashes = appendHash(hashes, f.Field)
+    }
+    for _, h := range hashes {
+        args = append(args, "GET", pattern + "->" + h)
+    }
+
+    r, err := db.Sort(key, args...)
+    if err != nil {
+        return nil, 0, err
+    }
+    values := r.StringArray()
+    width := len(hashes) + 1
+    if len(values) % width != 0 {
+        return nil, 0, errors.New("the fields of " + key + " do not match its IDs")
+    }
+
+    rows := make([]queryRow, 0, len(values) / width)
+    for i := 0; i < len(values); i += width {
+        row := queryRow{values[i], make(map[string]string)}
+        for j, h := range hashes {
+            row.fields[h] = values[i + j + 1]
+        }
+        rows = append(rows, row)
+    }
+
+    rows = selectRows(rows, q)
+    total := int64(len(rows))
+
+    // cut out the page
+    var ids []string
+    for i := page.Offset; i < len(rows) && i < page.Offset + page.Limit; i++ {
+        ids = append(ids, rows[i].id)
+    }
+
+    return ids, total, nil
+}
+
+// appendHash adds the hash field of f to hashes, unless it is the ID or
+// already there.
+func appendHash (hashes []string, f QueryField) []string {
+    if f.Hash == "" || containsString(hashes, f.Hash) {
+        return hashes
+    }
+
+    return append(hashes, f.Hash)
+}
+
+// queryRow is an entry of an index along with the fields of its object that
+// a Query compares.
+type queryRow struct {
+    // id is the ID of the object.
+    id string
+
+    // fields are the values of the fields compared, by their name in the
+    // hash.
+    fields map[string]string
+}
+
+// value returns the value of the field f of the row.
+func (row queryRow) value (f QueryField) string {
+    if f.Hash == "" {
+        return row.id
+    }
+
+    return row.fields[f.Hash]
+}
+
+// selectRows returns the rows that pass the filters of q, sorted by q. Rows
+// that sort equally are kept newest first.
+func selectRows (rows []queryRow, q Query) []queryRow {
+    var selected []queryRow
+    for _, row := range rows {
+        pass := true
+        for _, f := range q.Filters {
+            if ! f.matches(row.value(f.Field)) {
+                pass = false
+                break
+            }
+        }
+        if pass {
+            selected = append(selected, row)
+        }
+    }
+
+    keys := make([]SortKey, len(q.Sort), len(q.Sort) + 1)
+    copy(keys, q.Sort)
+    keys = append(keys, SortKey{idField, QueryField{Numeric: true}, true})
+    sort.Sort(sortedRows{selected, keys})
+
+    return selected
+}
+
+// sortedRows sorts rows by keys, for sort.Sort.
+type sortedRows struct {
+    rows []queryRow
+    keys []SortKey
+}
+
+func (s sortedRows) Len () int {
+    return len(s.rows)
+}
+
+func (s sortedRows) Swap (i, j int) {
+    s.rows[i], s.rows[j] = s.rows[j], s.rows[i]
+}
+
+func (s sortedRows) Less (i, j int) bool {
+    for _, k := range s.keys {
+        c := compareValues(s.rows[i].value(k.Field), s.rows[j].value(k.Field), k.Field.Numeric)
+        if c == 0 {
+            continue
+        }
+        if k.Desc {
+            return c > 0
+        }
+        return c < 0
+    }
+
+    return false
+}
+
+// compareValues returns -1, 0, or 1 as a is less than, equal to, or greater
+// than b, compared as numbers if numeric is set and as text otherwise. As
+// with SORT, missing or malformed numbers count as 0.
+func compareValues (a string, b string, numeric bool) int {
+    if numeric {
+        x, _ := strconv.ParseFloat(a, 64)
+        y, _ := strconv.ParseFloat(b, 64)
+        switch {
+        case x < y:
+            return -1
+        case x > y:
+            return 1
+        }
+        return 0
+    }
+
+    switch {
+    case a < b:
+        return -1
+    case a > b:
+        return 1
+    }
+    return 0
+}
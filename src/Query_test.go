@@ -0,0 +1,74 @@
+package main
+
+import (
+	"gospec"
+	. "gospec"
+	"net/http"
+	"net/url"
+)
+
+// QuerySpec specifies how the sorting and filtering of collections are
+// requested and carried out.
+func QuerySpec(c gospec.Context) {
+	parse := func(query string) (Query, error) {
+		request, _ := http.NewRequest("GET", "/resources?"+query, nil)
+		return ParseQuery(&WebContext{Request: request}, QueryFields(&Citation{}))
+	}
+
+	c.Specify("allows the stored fields of the JSON representation and the ID", func() {
+		fields := QueryFields(&Citation{})
+		c.Expect(len(fields), Equals, 7)
+		c.Expect(fields["id"].Hash, Equals, "")
+		c.Expect(fields["title"].Hash, Equals, "Title")
+		c.Expect(fields["authors"].List, IsTrue)
+
+		_, ok := QueryFields(&User{}, "secret")["secret"]
+		c.Expect(ok, IsFalse)
+	})
+
+	c.Specify("parses sort keys and filters", func() {
+		q, err := parse("sort=title,-id&filter=title~origin&filter=" + url.QueryEscape("provider=1001"))
+		c.Expect(err, IsNil)
+		c.Expect(len(q.Sort), Equals, 2)
+		c.Expect(q.Sort[0].Name, Equals, "title")
+		c.Expect(q.Sort[0].Desc, IsFalse)
+		c.Expect(q.Sort[1].Desc, IsTrue)
+		c.Expect(len(q.Filters), Equals, 2)
+		c.Expect(q.Filters[0].Op, Equals, "~")
+		c.Expect(q.Filters[1].Field.Hash, Equals, "Provider")
+		c.Expect(q.Filters[1].Value, Equals, "1001")
+	})
+
+	c.Specify("refuses unknown fields, sorts by lists, and malformed filters", func() {
+		_, err := parse("sort=colour")
+		c.Expect(err, Not(IsNil))
+		_, err = parse("sort=authors")
+		c.Expect(err, Not(IsNil))
+		_, err = parse("filter=colour~red")
+		c.Expect(err, Not(IsNil))
+		_, err = parse("filter=title")
+		c.Expect(err, Not(IsNil))
+	})
+
+	c.Specify("selects and sorts rows", func() {
+		rows := []queryRow{
+			{"1001", map[string]string{"Title": "On Liberty"}},
+			{"1002", map[string]string{"Title": "Origin of Species"}},
+			{"1010", map[string]string{"Title": "On Liberty"}},
+			{"1003", map[string]string{"Title": "Leviathan"}},
+		}
+		q, _ := parse("sort=title&filter=title~i")
+
+		selected := selectRows(rows, q)
+		c.Expect(len(selected), Equals, 4)
+		c.Expect(selected[0].id, Equals, "1003")
+		c.Expect(selected[1].id, Equals, "1010")
+		c.Expect(selected[2].id, Equals, "1001")
+		c.Expect(selected[3].id, Equals, "1002")
+
+		q, _ = parse("sort=-id&filter=title~liberty")
+		selected = selectRows(rows, q)
+		c.Expect(len(selected), Equals, 2)
+		c.Expect(selected[0].id, Equals, "1010")
+	})
+}
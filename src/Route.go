@@ -0,0 +1,259 @@
+package main
+
+import (
+    "errors"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// Routes are written as paths in which a segment may be a named parameter,
+// e.g. "/users/{uid}/texts/{tid:int}". A parameter matches a whole segment,
+// i.e. any text up to the next "/", unless it is constrained by a type:
+//
+//     {name}         any non-empty segment
+//     {name:int}     a non-negative integer
+//     {name:alpha}   letters only
+//
+// The last segment may instead be a wildcard, {name...}, which matches the
+// rest of the path, slashes included, as long as it is not empty.
+//
+// The values matched are available to the handler through WebContext.Param
+// and, in the order they appear, as the handler's string arguments after the
+// *WebContext.
+//
+// When several routes match a path, the one whose segments are most specific
+// wins, comparing segments from left to right: a literal segment beats a
+// typed parameter, which beats an untyped parameter, which beats a wildcard.
+// Only the path is compared, so a request using a method its route does not
+// support is refused rather than tried against less specific routes.
+
+// paramType is a type a route parameter may be constrained to.
+type paramType struct {
+    // name is the type as written in routes, e.g. "int".
+    name string
+
+    // match reports whether a segment is a value of the type.
+    match func(segment string) bool
+}
+
+// paramTypes are the types a route parameter may be constrained to, in the
+// order in which they are tried when matching a path.
+var paramTypes = []paramType{
+    {"int", func(s string) bool { return strings.Trim(s, "0123456789") == "" }},
+    {"alpha", func(s string) bool {
+        return strings.Trim(s, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz") == ""
+    }},
+    {"", func(s string) bool { return true }},
+}
+
+// paramPattern matches a segment of a route that is a parameter, capturing
+// its name and either its optional type or the "..." of a wildcard.
+var paramPattern = regexp.MustCompile(`^\{([A-Za-z_][A-Za-z0-9_]*)(?::([A-Za-z]+)|(\.\.\.))?\}$`)
+
+// The kinds of segment a route is made of.
+const (
+    staticSegment = iota
+    paramSegment
+    wildcardSegment
+)
+
+// routeSegment is a single segment of a parsed route.
+type routeSegment struct {
+    // kind is staticSegment, paramSegment, or wildcardSegment.
+    kind int
+
+    // value is the text of a static segment or the type of a parameter.
+    value string
+}
+
+// parseRoute splits a route into its segments, along with the names of its
+// parameters in order.
+func parseRoute(route string) ([]routeSegment, []string, error) {
+    if ! strings.HasPrefix(route, "/") {
+        return nil, nil, errors.New("route " + route + " does not start with /")
+    }
+
+    parts := strings.Split(route[1:], "/")
+    segments := make([]routeSegment, len(parts))
+    var names []string
+    for i, part := range parts {
+        m := paramPattern.FindStringSubmatch(part)
+        if m == nil {
+            // literal segments must match exactly
+            if strings.ContainsAny(part, "{}") {
+                return nil, nil, errors.New("malformed parameter in " + route + "; a parameter must be a whole segment")
+            }
+            segments[i] = routeSegment{staticSegment, part}
+            continue
+        }
+
+        name := m[1]
+        for _, n := range names {
+            if n == name {
+                return nil, nil, errors.New("duplicate parameter " + name)
+            }
+        }
+        names = append(names, name)
+
+        if m[3] != "" {
+            if i != len(parts) - 1 {
+                return nil, nil, errors.New("wildcard " + name + " must be the last segment of " + route)
+            }
+            segments[i] = routeSegment{wildcardSegment, ""}
+            continue
+        }
+
+        if _, ok := findParamType(m[2]); ! ok {
+            return nil, nil, errors.New("unknown type " + m[2] + " for parameter " + name)
+        }
+        segments[i] = routeSegment{paramSegment, m[2]}
+    }
+
+    return segments, names, nil
+}
+
+// findParamType returns the parameter type with the given name.
+func findParamType(name string) (paramType, bool) {
+    for _, t := range paramTypes {
+        if t.name == name {
+            return t, true
+        }
+    }
+
+    return paramType{}, false
+}
+
+// splitPath splits the path of a request into the segments matched against
+// routes.
+func splitPath(path string) []string {
+    if path == "" {
+        path = "/"
+    }
+
+    return strings.Split(path[1:], "/")
+}
+
+// routeNode is a node of the tree of routes a Server dispatches requests
+// with. Each node stands for a segment of a route; the Handlers of a route are
+// stored on the node of its last segment.
+type routeNode struct {
+    // static holds the children for literal segments, by their text.
+    static map[string]*routeNode
+
+    // params holds the children for parameters, by their type.
+    params map[string]*routeNode
+
+    // wildcard is the child for a wildcard, if any.
+    wildcard *routeNode
+
+    // handlers holds the Handlers of the route ending here, by method.
+    handlers map[string]Handler
+
+    // methods are the keys of handlers, in the order they were added.
+    methods []string
+}
+
+// newRouteNode creates an empty routeNode.
+func newRouteNode() *routeNode {
+    return &routeNode{
+        static: make(map[string]*routeNode),
+        params: make(map[string]*routeNode),
+        handlers: make(map[string]Handler),
+    }
+}
+
+// add stores the Handler under the node for the given segments, creating the
+// nodes that are missing. It fails if a Handler is already stored there for
+// the same method.
+func (n *routeNode) add(segments []routeSegment, h Handler) error {
+    for _, s := range segments {
+        var child *routeNode
+        switch s.kind {
+        case staticSegment:
+            child = n.static[s.value]
+            if child == nil {
+                child = newRouteNode()
+                n.static[s.value] = child
+            }
+        case paramSegment:
+            child = n.params[s.value]
+            if child == nil {
+                child = newRouteNode()
+                n.params[s.value] = child
+            }
+        case wildcardSegment:
+            if n.wildcard == nil {
+                n.wildcard = newRouteNode()
+            }
+            child = n.wildcard
+        }
+        n = child
+    }
+
+    if _, ok := n.handlers[h.Method]; ok {
+        return errors.New("another route for " + h.Method + " matches the same paths")
+    }
+    n.handlers[h.Method] = h
+    n.methods = append(n.methods, h.Method)
+
+    return nil
+}
+
+// match finds the node of the most specific route matching the segments of a
+// path, along with the values of its parameters appended to values. It
+// returns nil if no route matches.
+func (n *routeNode) match(segments []string, values []string) (*routeNode, []string) {
+    if len(segments) == 0 {
+        if len(n.methods) == 0 {
+            return nil, nil
+        }
+        return n, values
+    }
+    segment := segments[0]
+
+    // literal segments are the most specific
+    if child, ok := n.static[segment]; ok {
+        if found, v := child.match(segments[1:], values); found != nil {
+            return found, v
+        }
+    }
+
+    // then parameters, in the order of paramTypes
+    if segment != "" {
+        for _, t := range paramTypes {
+            child, ok := n.params[t.name]
+            if ! ok || ! t.match(segment) {
+                continue
+            }
+            if found, v := child.match(segments[1:], append(values, segment)); found != nil {
+                return found, v
+            }
+        }
+    }
+
+    // and finally a wildcard taking the rest of the path
+    if n.wildcard != nil && len(n.wildcard.methods) > 0 {
+        if rest := strings.Join(segments, "/"); rest != "" {
+            return n.wildcard, append(values, rest)
+        }
+    }
+
+    return nil, nil
+}
+
+// Params holds the values of the parameters of the route matching a request,
+// by name.
+type Params map[string]string
+
+// Int returns the value of the named parameter as an integer. It fails if the
+// parameter is missing or, e.g., too large, even when the route constrains it
+// to {name:int}.
+func (p Params) Int(name string) (int64, error) {
+    value, ok := p[name]
+    if ! ok {
+        return 0, errors.New("no parameter named " + name)
+    }
+
+    return strconv.ParseInt(value, 10, 64)
+}
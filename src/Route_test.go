@@ -0,0 +1,127 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+// benchRoutes is the route table of the API, as registered in main, each
+// route including the prefix of its group.
+var benchRoutes = []struct{ method, route string }{
+	{"GET", "/"},
+	{"GET", "/v1.0/providers"},
+	{"POST", "/v1.0/providers"},
+	{"GET", "/v1.0/providers/{id:int}"},
+	{"PUT", "/v1.0/providers/{id:int}"},
+	{"PATCH", "/v1.0/providers/{id:int}"},
+	{"DELETE", "/v1.0/providers/{id:int}"},
+	{"GET", "/v1.0/users"},
+	{"POST", "/v1.0/users"},
+	{"GET", "/v1.0/users/{id:int}"},
+	{"PUT", "/v1.0/users/{id:int}"},
+	{"PATCH", "/v1.0/users/{id:int}"},
+	{"DELETE", "/v1.0/users/{id:int}"},
+	{"GET", "/v1.0/users/{uid:int}/texts"},
+	{"POST", "/v1.0/users/{uid:int}/texts"},
+	{"GET", "/v1.0/users/{uid:int}/texts/{tid:int}"},
+	{"PUT", "/v1.0/users/{uid:int}/texts/{tid:int}"},
+	{"PATCH", "/v1.0/users/{uid:int}/texts/{tid:int}"},
+	{"DELETE", "/v1.0/users/{uid:int}/texts/{tid:int}"},
+	{"GET", "/v1.0/users/{uid:int}/resources"},
+	{"POST", "/v1.0/users/{uid:int}/resources"},
+	{"GET", "/v1.0/users/{uid:int}/resources/{rid:int}"},
+	{"PUT", "/v1.0/users/{uid:int}/resources/{rid:int}"},
+	{"PATCH", "/v1.0/users/{uid:int}/resources/{rid:int}"},
+	{"DELETE", "/v1.0/users/{uid:int}/resources/{rid:int}"},
+	{"GET", "/v1.0/search"},
+}
+
+// benchPaths are the paths routed in each iteration of the benchmarks, from
+// the first route registered to the last.
+var benchPaths = []string{
+	"/",
+	"/v1.0/providers/1001",
+	"/v1.0/users/1001",
+	"/v1.0/users/1001/texts/42",
+	"/v1.0/users/1001/resources/42",
+	"/v1.0/search",
+	"/v1.0/users/1001/bookmarks",
+}
+
+// scanRoute is a route in the slice scanned by the router the trie replaced,
+// which tried the regular expression of every route in turn.
+type scanRoute struct {
+	method string
+	uri    *regexp.Regexp
+}
+
+// scanTypes are the regular expressions matching each parameter type.
+var scanTypes = map[string]string{
+	"":      "[^/]+",
+	"int":   "[0-9]+",
+	"alpha": "[A-Za-z]+",
+}
+
+// compileScanRoute converts a route into a scanRoute.
+func compileScanRoute(method string, route string) scanRoute {
+	segments, _, err := parseRoute(route)
+	if err != nil {
+		panic(err)
+	}
+
+	expr := "^"
+	for _, s := range segments {
+		switch s.kind {
+		case staticSegment:
+			expr += "/" + regexp.QuoteMeta(s.value)
+		case paramSegment:
+			expr += "/(" + scanTypes[s.value] + ")"
+		case wildcardSegment:
+			expr += "/(.+)"
+		}
+	}
+
+	return scanRoute{method, regexp.MustCompile(expr + "$")}
+}
+
+// scan finds the route for the request as the old router did, returning the
+// values of its parameters.
+func scan(routes []scanRoute, method string, path string) []string {
+	for _, r := range routes {
+		if r.method == method && r.uri.MatchString(path) {
+			return r.uri.FindStringSubmatch(path)[1:]
+		}
+	}
+
+	return nil
+}
+
+func BenchmarkScanRouter(b *testing.B) {
+	var routes []scanRoute
+	for _, r := range benchRoutes {
+		routes = append(routes, compileScanRoute(r.method, r.route))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range benchPaths {
+			scan(routes, "DELETE", path)
+		}
+	}
+}
+
+func BenchmarkTrieRouter(b *testing.B) {
+	srv := NewServer()
+	for _, r := range benchRoutes {
+		srv.addRoute(r.method, r.route, func(ctx *WebContext) {}, nil)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range benchPaths {
+			if node, _ := srv.routes.match(splitPath(path), nil); node != nil {
+				_ = node.handlers["DELETE"]
+			}
+		}
+	}
+}
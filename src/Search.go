@@ -0,0 +1,432 @@
+package main
+
+import (
+    "errors"
+    "godis"
+    "html"          // for escaping snippets
+    "math"          // for weighting rare terms
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+// Fields of objects persisted with SaveHashes are made searchable by a
+// "search" struct tag giving their weight, a field in which a term is more
+// telling counting for more:
+//
+//     Name        string   `search:"3"`    // a match counts three times
+//     Description string   `search:"1"`
+//
+// Strings and slices of strings can be searched. Each time an object is
+// saved, its searchable fields are split into terms, which are stemmed and
+// stripped of stop words; see Terms. The terms are written to an inverted
+// index: a hash at "search:term:TERM" from the key of each object containing
+// the term to the weighted number of times it does. A set at
+// "search:terms:KEY" keeps the terms of each object, so that they can be
+// removed when the object is saved again or deleted, and a set at
+// "search:docs" the keys of all searchable objects.
+
+// searchDocsKey is the key of the set of the keys of all searchable objects.
+const searchDocsKey = "search:docs"
+
+// searchTermKey returns the key of the hash of the objects containing term.
+func searchTermKey (term string) string {
+    return "search:term:" + term
+}
+
+// searchTermsKey returns the key of the set of the terms of the object at key.
+func searchTermsKey (key string) string {
+    return "search:terms:" + key
+}
+
+// stopWords are the English words too common to be worth searching for.
+var stopWords = map[string]bool{}
+
+func init() {
+    for _, w := range strings.Fields(`a about above after again against all am an
+        and any are as at be because been before being below between both but by
+        can did do does doing down during each few for from further had has have
+        having he her here hers herself him himself his how i if in into is it its
+        itself just me more most my myself no nor not now of off on once only or
+        other our ours ourselves out over own same she should so some such than
+        that the their theirs them themselves then there these they this those
+        through to too under until up very was we were what when where which while
+        who whom why will with you your yours yourself yourselves`) {
+        stopWords[w] = true
+    }
+}
+
+// word is a word of a text, from its byte offset start up to end.
+type word struct {
+    start int
+    end   int
+}
+
+// splitWords returns the words of text: its runs of letters and digits.
+func splitWords (text string) []word {
+    var words []word
+    start := -1
+    for i, r := range text {
+        inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+        switch {
+        case inWord && start < 0:
+            start = i
+        case ! inWord && start >= 0:
+            words = append(words, word{start, i})
+            start = -1
+        }
+    }
+    if start >= 0 {
+        words = append(words, word{start, len(text)})
+    }
+
+    return words
+}
+
+// term returns the search term for the word w, or "" for a stop word. Words
+// are compared in lower case, and English words by their stem.
+func term (w string) string {
+    w = strings.ToLower(w)
+    if stopWords[w] {
+        return ""
+    }
+
+    for _, r := range w {
+        if r < 'a' || r > 'z' {
+            return w
+        }
+    }
+
+    return Stem(w)
+}
+
+// Terms returns the search terms of text, in order and with repetitions.
+func Terms (text string) []string {
+    var terms []string
+    for _, w := range splitWords(text) {
+        if t := term(text[w.start:w.end]); t != "" {
+            terms = append(terms, t)
+        }
+    }
+
+    return terms
+}
+
+// searchField is the text of a searchable field, with its weight.
+type searchField struct {
+    text   string
+    weight int
+}
+
+// searchFields returns the searchable fields of obj, a struct or a pointer to
+// one, the heaviest first. Fields whose "search" tag is malformed are not
+// searched.
+func searchFields (obj interface{}) []searchField {
+    v := reflect.ValueOf(obj)
+    if v.Kind() == reflect.Ptr {
+        v = v.Elem()
+    }
+    t := v.Type()
+
+    var fields []searchField
+    for i := 0; i < t.NumField(); i++ {
+        ft := t.Field(i)
+        weight, err := strconv.Atoi(ft.Tag.Get("search"))
+        if err != nil || weight < 1 || ft.PkgPath != "" {
+            continue
+        }
+
+        fv := v.Field(i)
+        switch {
+        case fv.Kind() == reflect.String:
+            fields = append(fields, searchField{fv.String(), weight})
+        case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String:
+            texts := make([]string, fv.Len())
+            for j := range texts {
+                texts[j] = fv.Index(j).String()
+            }
+            fields = append(fields, searchField{strings.Join(texts, ", "), weight})
+        }
+    }
+
+    sort.Sort(byWeight(fields))
+    return fields
+}
+
+// byWeight sorts searchFields the heaviest first, for sort.Sort.
+type byWeight []searchField
+
+func (f byWeight) Len () int           { return len(f) }
+func (f byWeight) Swap (i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byWeight) Less (i, j int) bool { return f[i].weight > f[j].weight }
+
+// searchable reports whether objects of the type of obj have searchable
+// fields.
+func searchable (obj interface{}) bool {
+    t := reflect.TypeOf(obj)
+    if t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+
+    for i := 0; i < t.NumField(); i++ {
+        if t.Field(i).Tag.Get("search") != "" {
+            return true
+        }
+    }
+
+    return false
+}
+
+// searchTerms returns the weighted number of times each term appears in the
+// searchable fields of obj.
+func searchTerms (obj interface{}) map[string]int {
+    terms := make(map[string]int)
+    for _, f := range searchFields(obj) {
+        for _, t := range Terms(f.text) {
+            terms[t] += f.weight
+        }
+    }
+
+    return terms
+}
+
+// watchSearchTerms WATCHes the sets of the terms of the searchable objects
+// among objs on the connection of pipe, so that a transaction on it fails if
+// another changes them after they are read with readSearchTerms.
+func watchSearchTerms (pipe *godis.PipeClient, objs []DbObject) error {
+    var keys []string
+    for _, obj := range objs {
+        if searchable(obj) {
+            keys = append(keys, searchTermsKey(obj.GetKey()))
+        }
+    }
+    if len(keys) == 0 {
+        return nil
+    }
+
+    return pipe.Watch(keys...)
+}
+
+// readSearchTerms returns the terms under which the searchable objects among
+// objs are currently indexed, by key, so that they can be removed from the
+// index in a transaction.
+func readSearchTerms (db *godis.Client, objs []DbObject) (map[string][]string, error) {
+    indexed := make(map[string][]string)
+    for _, obj := range objs {
+        key := obj.GetKey()
+        if _, ok := indexed[key]; ok || ! searchable(obj) {
+            continue
+        }
+
+        r, err := db.Smembers(searchTermsKey(key))
+        if err != nil {
+            return nil, err
+        }
+        indexed[key] = r.StringArray()
+    }
+
+    return indexed, nil
+}
+
+// queueUnsearch removes the object at key, indexed under terms, from the
+// search index as part of the transaction in pipe.
+func queueUnsearch (pipe *godis.PipeClient, key string, terms []string) {
+    for _, t := range terms {
+        pipe.Hdel(searchTermKey(t), key)
+    }
+    pipe.Del(searchTermsKey(key))
+    pipe.Srem(searchDocsKey, key)
+}
+
+// queueSearch adds obj to the search index as part of the transaction in
+// pipe. Any terms it was indexed under before must have been removed first.
+func queueSearch (pipe *godis.PipeClient, obj DbObject) {
+    if ! searchable(obj) {
+        return
+    }
+
+    key := obj.GetKey()
+    for t, n := range searchTerms(obj) {
+        pipe.Hset(searchTermKey(t), key, n)
+        pipe.Sadd(searchTermsKey(key), t)
+    }
+    pipe.Sadd(searchDocsKey, key)
+}
+
+// snippetWords is the number of words in a snippet.
+const snippetWords = 20
+
+// Snippet returns the part of the searchable fields of obj that best matches
+// the given search terms, as HTML in which the matching words are
+// emphasized, e.g. "the National <em>Library</em> of Medicine". It is empty
+// if no field matches.
+func Snippet (obj interface{}, terms []string) string {
+    // find the field with the most weighted matches
+    var best searchField
+    var bestWords []word
+    var bestMatches []bool
+    bestScore, first := 0, 0
+    for _, f := range searchFields(obj) {
+        words := splitWords(f.text)
+        matches := make([]bool, len(words))
+        score, firstMatch := 0, -1
+        for i, w := range words {
+            if containsString(terms, term(f.text[w.start:w.end])) {
+                matches[i] = true
+                score += f.weight
+                if firstMatch < 0 {
+                    firstMatch = i
+                }
+            }
+        }
+
+        if score > bestScore {
+            best, bestWords, bestMatches = f, words, matches
+            bestScore, first = score, firstMatch
+        }
+    }
+    if bestScore == 0 {
+        return ""
+    }
+
+    // show a few words of context before the first match, more if the text
+    // ends soon after it
+    from := first - 3
+    if from > len(bestWords) - snippetWords {
+        from = len(bestWords) - snippetWords
+    }
+    if from < 0 {
+        from = 0
+    }
+    to := from + snippetWords
+    if to > len(bestWords) {
+        to = len(bestWords)
+    }
+
+    text := best.text
+    snippet := ""
+    if from > 0 {
+        snippet = "..."
+    }
+    pos := bestWords[from].start
+    for i := from; i < to; i++ {
+        w := bestWords[i]
+        snippet += html.EscapeString(text[pos:w.start])
+        if bestMatches[i] {
+            snippet += "<em>" + html.EscapeString(text[w.start:w.end]) + "</em>"
+        } else {
+            snippet += html.EscapeString(text[w.start:w.end])
+        }
+        pos = w.end
+    }
+    if to < len(bestWords) {
+        snippet += "..."
+    } else {
+        snippet += html.EscapeString(text[pos:])
+    }
+
+    return snippet
+}
+
+// searchHit is an object matching a search, with its score.
+type searchHit struct {
+    key   string
+    score float64
+}
+
+// byScore sorts searchHits the best first, and equal ones by key, for
+// sort.Sort.
+type byScore []searchHit
+
+func (h byScore) Len () int      { return len(h) }
+func (h byScore) Swap (i, j int) { h[i], h[j] = h[j], h[i] }
+func (h byScore) Less (i, j int) bool {
+    if h[i].score != h[j].score {
+        return h[i].score > h[j].score
+    }
+    return h[i].key < h[j].key
+}
+
+// Search returns one Page of the objects matching the words of q, the best
+// matches first, along with the total number of matches. An object matches if
+// it contains any of the words; its score adds up, for each of them, the
+// weighted number of times it appears, weighted again by how rare it is among
+// all objects. The Resources carry their score and a Snippet, and URIs built
+// from the routes of srv.
+func Search (db *godis.Client, srv *Server, q string, page Page) ([]Resource, int64, error) {
+    var results []Resource
+
+    // look up each distinct term
+    var terms []string
+    for _, t := range Terms(q) {
+        if ! containsString(terms, t) {
+            terms = append(terms, t)
+        }
+    }
+    if len(terms) == 0 {
+        return results, 0, nil
+    }
+
+    docs, err := db.Scard(searchDocsKey)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    scores := make(map[string]float64)
+    for _, t := range terms {
+        r, err := db.Hgetall(searchTermKey(t))
+        if err != nil {
+            return nil, 0, err
+        }
+        postings := r.StringMap()
+        if len(postings) == 0 {
+            continue
+        }
+
+        idf := math.Log(1 + float64(docs) / float64(len(postings)))
+        for key, n := range postings {
+            weight, err := strconv.Atoi(n)
+            if err != nil {
+                return nil, 0, errors.New("malformed search index for " + t + ": " + err.Error())
+            }
+            scores[key] += float64(weight) * idf
+        }
+    }
+
+    hits := make([]searchHit, 0, len(scores))
+    for key, score := range scores {
+        hits = append(hits, searchHit{key, score})
+    }
+    sort.Sort(byScore(hits))
+
+    // load the objects of the page, leaving out those deleted since they
+    // were looked up
+    for i := page.Offset; i < len(hits) && i < page.Offset + page.Limit; i++ {
+        obj, err := loadKey(db, hits[i].key)
+        if err == ErrNotFound {
+            continue
+        } else if err != nil {
+            return nil, 0, err
+        }
+
+        r := NewResource(srv, obj)
+        r.Score = hits[i].score
+        r.Snippet = Snippet(obj, terms)
+        results = append(results, r)
+    }
+
+    return results, int64(len(hits)), nil
+}
+
+// loadKey loads the object stored at key through its registered Model.
+func loadKey (db *godis.Client, key string) (DbObject, error) {
+    for _, m := range Models {
+        if strings.HasPrefix(key, m.Prefix) {
+            return m.Load(db, key[len(m.Prefix):])
+        }
+    }
+
+    return nil, errors.New("no model is stored at " + key)
+}
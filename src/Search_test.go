@@ -0,0 +1,54 @@
+package main
+
+import (
+	"gospec"
+	. "gospec"
+)
+
+// SearchSpec specifies how text is split into search terms and how matches
+// are shown.
+func SearchSpec(c gospec.Context) {
+	c.Specify("stems English words", func() {
+		c.Expect(Stem("libraries"), Equals, "librari")
+		c.Expect(Stem("library"), Equals, "librari")
+		c.Expect(Stem("hopping"), Equals, "hop")
+		c.Expect(Stem("relational"), Equals, "relat")
+		c.Expect(Stem("generalization"), Equals, "gener")
+		c.Expect(Stem("sky"), Equals, "sky")
+		c.Expect(Stem("is"), Equals, "is")
+	})
+
+	c.Specify("splits text into terms without stop words", func() {
+		c.Expect(Terms("The National Library of Medicine's libraries"), ContainsInOrder,
+			Values("nation", "librari", "medicin", "s", "librari"))
+		c.Expect(len(Terms("of the and")), Equals, 0)
+		c.Expect(Terms("ISBN 0262510871, naïve"), ContainsInOrder, Values("isbn", "0262510871", "naïve"))
+	})
+
+	c.Specify("weighs terms by field", func() {
+		p := &Provider{Name: "Open Library", Description: "A library for everyone"}
+		terms := searchTerms(p)
+		c.Expect(terms["librari"], Equals, 4)
+		c.Expect(terms["open"], Equals, 3)
+		c.Expect(terms["everyon"], Equals, 1)
+
+		c.Expect(searchable(p), IsTrue)
+		c.Expect(searchable(&User{}), IsFalse)
+	})
+
+	c.Specify("emphasizes matching words in snippets", func() {
+		p := &Provider{
+			Name:        "OpenLibrary.org",
+			Description: "Open to all, one web page for every book ever published, whether in print or <lost>, kept with care by the libraries of the world.",
+		}
+
+		c.Expect(Snippet(p, Terms("libraries")), Equals,
+			"...page for every book ever published, whether in print or &lt;lost&gt;, kept with care by the <em>libraries</em> of the world.")
+		c.Expect(Snippet(p, Terms("book")), Equals,
+			"...page for every <em>book</em> ever published, whether in print or &lt;lost&gt;, kept with care by the libraries of the world.")
+		c.Expect(Snippet(p, Terms("open")), Equals,
+			"<em>Open</em> to all, one web page for every book ever published, whether in print or &lt;lost&gt;, kept with care by...")
+		c.Expect(Snippet(p, Terms("openlibrary")), Equals, "<em>OpenLibrary</em>.org")
+		c.Expect(Snippet(p, Terms("medicine")), Equals, "")
+	})
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bytes"                // for encoding errors before aborting
+	"crypto/rand"          // for request IDs
+	"encoding/hex"         // for request IDs
 	"net/http"             // powers the main api
-        "regexp"               // for parsing URIs
+        "io"
         "log"
         "reflect"              // for processing router handlers
+        "runtime/debug"        // for logging the stack of panicking handlers
+        "strconv"              // for the Content-Length of HEAD requests
+        "strings"              // for the Allow header
+        "time"
 )
 
 // Handler represents a function handler for a specified URI. Server's Get,
@@ -14,15 +21,31 @@ type Handler struct {
     // Method is the HTTP request method for which this Handler can respond.
     Method string
 
-    // Uri is a regular expression of the URIs to which this Handler can
-    // respond.
-    Uri *regexp.Regexp
+    // Route is the route, e.g. "/users/{uid:int}", to which this Handler
+    // responds.
+    Route string
+
+    // Params are the names of the parameters of Route, in order.
+    Params []string
 
     // Handler is a reflect.Value representation of a function to handle the
     // request.
     Handler reflect.Value
+
+    // Middleware is run, after the Server's global middleware, before
+    // Handler.
+    Middleware []Middleware
 }
 
+// Middleware wraps the handling of a request. It may do work before and after
+// calling next, which runs the rest of the chain and finally the handler, or
+// end the request early by calling ctx.Abort (or ctx.Error) instead.
+type Middleware func(ctx *WebContext, next func())
+
+// Hook is run once the response to a request has been written, e.g. to log or
+// record metrics about it.
+type Hook func(ctx *WebContext)
+
 // Server represents the HTTP server responsible for processing URIs by a set
 // of handlers.
 type Server struct {
@@ -30,6 +53,19 @@ type Server struct {
     // Handlers is an array of registered Handlers the server can use to
     // respond to an HTTP request.
     Handlers []Handler
+
+    // routes is the tree of routes the Handlers are looked up in.
+    routes *routeNode
+
+    // names maps the names given to routes onto the routes, for building
+    // links.
+    names map[string]string
+
+    // middleware is run, in order, for every request before it is routed.
+    middleware []Middleware
+
+    // after is run, in order, once every request has been handled.
+    after []Hook
 }
 
 // WebContext represents the context under which a particular Handler is invoked.
@@ -41,9 +77,42 @@ type WebContext struct {
     // Request represents the HTTP request, including its header and body.
     Request *http.Request
 
+    // User is the User who signed the request, once IsAuthenticated has
+    // succeeded. It is nil otherwise.
+    User *User
+
+    // Params holds the values of the parameters of the matched route.
+    Params Params
+
+    // RequestId uniquely identifies the request in the server log and in
+    // error responses.
+    RequestId string
+
     // conn is an internal construct used by WebContext functions for rendering
     // or manipulating the response.
     conn http.ResponseWriter
+
+    // status is the response code sent to the client, or 0 if nothing has
+    // been sent yet.
+    status int
+
+    // aborted is set once Abort ends the request, so that no further
+    // middleware or handler runs.
+    aborted bool
+
+    // started is the time at which the request arrived.
+    started time.Time
+
+    // encoding produces the representation of the response negotiated with
+    // the client.
+    encoding encoding
+
+    // pretty is set if the representation is to be indented.
+    pretty bool
+
+    // server is the Server handling the request, whose named routes links
+    // are built from.
+    server *Server
 }
 
 // NewServer creates a new HTTP Server.
@@ -51,6 +120,7 @@ func NewServer () Server {
     // create a new server, allowing a maximum of 250 URI handlers.
     var srv Server
     srv.Handlers = make([]Handler, 0, 250)
+    srv.routes = newRouteNode()
     return srv
 }
 
@@ -63,75 +133,222 @@ func (srv *Server) Start (host string) {
 }
 
 // ServeHTTP implements http.Handler's ServeHTTP function and is responsible
-// for processing all requests to the server.
+// for processing all requests to the server. The request is passed through
+// the global middleware, then the middleware of the matching route, and
+// finally the route's handler; the after-response hooks run last.
 func (srv *Server) ServeHTTP (response http.ResponseWriter, request *http.Request) {
+    // generate the WebContext object
+    ctx := WebContext{Header: response.Header(), Request: request, conn: response, server: srv}
+    ctx.RequestId = newRequestId()
+    ctx.started = time.Now()
+
+    // let the client refer to the request when reporting problems
+    ctx.Header.Set("X-Request-Id", ctx.RequestId)
+
+    // choose the representation of the response from the format parameter
+    // or the Accept header, falling back on the preferred one to explain when
+    // none is acceptable
+    enc, acceptable := negotiateRequest(request)
+    if ! acceptable {
+        enc = encodings[0]
+    }
+    ctx.encoding = enc
+    ctx.pretty = prettyRequest(request)
+    ctx.Header.Set("Content-Type", enc.mediaType)
+    ctx.Header.Add("Vary", "Accept")
+
+    // HEAD requests are handled as GET requests whose body is discarded
+    var head *headWriter
+    if request.Method == "HEAD" {
+        head = &headWriter{ResponseWriter: response}
+        ctx.conn = head
+    }
+
+    // run the global middleware around the routing of the request
+    ctx.call(func() {
+        ctx.chain(srv.middleware, func() {
+            if ! acceptable {
+                ctx.Error(406, "The response can only be represented as " + strings.Join(SupportedTypes(), ", ") + ".")
+                return
+            }
+            srv.route(&ctx)
+        })
+    })
+
+    if head != nil {
+        head.finish()
+    }
+
+    // the response is complete, so run the hooks
+    for _, hook := range srv.after {
+        ctx.hook(hook)
+    }
+}
+
+// route finds the Handler for the request and runs it, along with the
+// middleware registered for its route. If the URI is known but no Handler
+// supports the method, the request is answered with 405 Method Not Allowed,
+// or, for OPTIONS, with the methods that are supported.
+func (srv *Server) route (ctx *WebContext) {
     // create some convenience variables for use in comparing the actual
     // request to the various request handlers.
-    targetMethod := request.Method
-    targetUri := request.URL.Path
-    targetQuery := request.URL.RawQuery
+    targetMethod := ctx.Request.Method
+    targetUri := ctx.Request.URL.Path
 
-    // log the request
-    if len(request.URL.RawQuery) == 0 {
-        log.Println(targetMethod + " " + targetUri)
-    } else {
-        log.Println(targetMethod + " " + targetUri + "?" + targetQuery)
+    // every GET handler answers HEAD requests as well
+    if targetMethod == "HEAD" {
+        targetMethod = "GET"
     }
 
-    // generate the WebContext object
-    ctx := WebContext{response.Header(), request, response}
-
-    // set the default headers
-    ctx.Header.Set("Content-type", "application/json")
+    // find the most specific route matching the URI
+    var node *routeNode
+    var values []string
+    if srv.routes != nil {
+        node, values = srv.routes.match(splitPath(targetUri), nil)
+    }
 
-    // search srv.Handlers for a compatible match
-    match := false
-    for i := 0; i < len(srv.Handlers); i++ {
-        // create some convenience variables for comparing this handler to the
-        // actual request
-        thisMethod := srv.Handlers[i].Method
-        thisUri := srv.Handlers[i].Uri
+    // if there was no matching route, we should return a 404 error
+    if node == nil {
+        ctx.Error(404, "Resource does not exist.")
+        return
+    }
 
-        // if we find a match...
-        if thisMethod == targetMethod && thisUri.MatchString(targetUri) {
-            // unravel URL parameters and map somewhere in the WebContext
-            matchedParams := thisUri.FindStringSubmatch(targetUri)
+    if h, ok := node.handlers[targetMethod]; ok {
+        // map the URL parameters in the WebContext
+        ctx.Params = make(Params)
+        for j, name := range h.Params {
+            ctx.Params[name] = values[j]
+        }
 
-            // create the args to pass to the handler function
-            var args []reflect.Value
+        // create the args to pass to the handler function
+        var args []reflect.Value
 
-            // we always include the context
-            args = append(args, reflect.ValueOf(&ctx))
+        // we always include the context
+        args = append(args, reflect.ValueOf(ctx))
 
-            for _, arg := range matchedParams[1:] {
+        // handlers that take more than the context take every parameter
+        if h.Handler.Type().NumIn() > 1 {
+            for _, arg := range values {
                 args = append(args, reflect.ValueOf(arg))
             }
+        }
+
+        // call the function once the route's middleware allows it
+        ctx.chain(h.Middleware, func() {
+            h.Handler.Call(args)
+        })
+        return
+    }
 
-            // call the function
-            // FIXME: this should be called safely
-            srv.Handlers[i].Handler.Call(args)
+    // the route does not support the method, so tell the client which
+    // methods it may use instead
+    var allowed []string
+    for _, m := range node.methods {
+        allowed = appendMethod(allowed, m)
+    }
+    allowed = append(allowed, "OPTIONS")
+    ctx.Header.Set("Allow", strings.Join(allowed, ", "))
+
+    if targetMethod == "OPTIONS" {
+        ctx.WriteHeader(204)
+        return
+    }
 
-            // we have a match, so we're done
-            match = true
-            break
+    ctx.Error(405, "The resource does not support the " + ctx.Request.Method + " method.")
+}
+
+// appendMethod adds method to methods unless it is already there. HEAD is
+// added along with GET, as GET handlers answer HEAD requests.
+func appendMethod (methods []string, method string) []string {
+    for _, m := range methods {
+        if m == method {
+            return methods
         }
     }
 
-    // if there was no matching route, we should return a 404 error
-    if ! match {
-        err404 := MessageError{404, "Resource does not exist."}
-        response.WriteHeader(404)
-        response.Write(err404.Json())
+    methods = append(methods, method)
+    if method == "GET" {
+        methods = append(methods, "HEAD")
+    }
+
+    return methods
+}
+
+// headWriter is an http.ResponseWriter that answers a HEAD request with the
+// headers a GET handler produces, counting but discarding the body. The
+// headers are only sent by finish, so that they can include the length of the
+// body.
+type headWriter struct {
+    http.ResponseWriter
+
+    // status is the response code set by the handler.
+    status int
+
+    // length is the number of bytes of body the handler wrote.
+    length int
+}
+
+// WriteHeader records the response code until finish sends it.
+func (w *headWriter) WriteHeader (code int) {
+    if w.status == 0 {
+        w.status = code
     }
 }
 
+// Write counts the bytes of body, then discards them.
+func (w *headWriter) Write (body []byte) (int, error) {
+    if w.status == 0 {
+        w.status = 200
+    }
+    w.length += len(body)
+    return len(body), nil
+}
+
+// finish sends the headers, including a Content-Length matching the body the
+// handler would have sent for a GET request.
+func (w *headWriter) finish () {
+    if w.status == 0 {
+        w.status = 200
+    }
+
+    header := w.Header()
+    if header.Get("Content-Length") == "" && w.status != 204 && w.status != 304 {
+        header.Set("Content-Length", strconv.Itoa(w.length))
+    }
+
+    w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Use adds middleware that is run for every request, in the order added,
+// before the request is routed.
+func (srv *Server) Use (mw ...Middleware) {
+    srv.middleware = append(srv.middleware, mw...)
+}
+
+// After adds hooks that are run, in the order added, once a request has been
+// handled, even if it was aborted.
+func (srv *Server) After (hooks ...Hook) {
+    srv.after = append(srv.after, hooks...)
+}
+
+// newRequestId returns a random identifier for a request.
+func newRequestId () string {
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+        // the ID is only a convenience, so don't fail the request over it
+        log.Printf("Could not generate a request ID: %s", err)
+    }
+
+    return hex.EncodeToString(b)
+}
+
 // addRoute is an internal function that adds a new function handler
-func (srv *Server) addRoute (method string, uri string, handler interface{}) {
-    // we are only going to store the compiled URI regex
-    re, err := regexp.Compile("^" + uri + "$")
+func (srv *Server) addRoute (method string, uri string, handler interface{}, mw []Middleware) Route {
+    // parse the route once, here, rather than on every request
+    segments, params, err := parseRoute(uri)
     if err != nil {
-        log.Fatalf("Error in route regular expression: %s", uri)
-        return
+        log.Fatalf("Error in route %s: %s", uri, err)
+        return Route{}
     }
 
     // get the reflect.Type and reflect.Value of the handler
@@ -141,7 +358,7 @@ func (srv *Server) addRoute (method string, uri string, handler interface{}) {
     // log a fatal error if handler is not a function
     if handlerType.Kind() != reflect.Func {
         log.Fatalf("Handler must be a function for route %s %s . %s", method, uri, handlerType.Kind().String())
-        return
+        return Route{}
     }
 
     // ensure the handler takes at least 1 arg, that is a ptr, to a WebContext
@@ -149,33 +366,70 @@ func (srv *Server) addRoute (method string, uri string, handler interface{}) {
        handlerType.In(0).Kind() != reflect.Ptr ||
        handlerType.In(0).Elem() != reflect.TypeOf(WebContext{}) {
         log.Fatalf("Handler function must take a *WebContext as its first parameter in route %s %s", method, uri)
-        return
+        return Route{}
+    }
+
+    // ensure the handler either takes only the context, reading parameters
+    // through WebContext.Param, or takes every parameter as a string
+    if handlerType.NumIn() > 1 {
+        if handlerType.NumIn() - 1 != len(params) || handlerType.IsVariadic() {
+            log.Fatalf("Handler function must take a string for each of the %d parameters in route %s %s", len(params), method, uri)
+            return Route{}
+        }
+        for i := 1; i < handlerType.NumIn(); i++ {
+            if handlerType.In(i).Kind() != reflect.String {
+                log.Fatalf("Handler function must take a string for each of the %d parameters in route %s %s", len(params), method, uri)
+                return Route{}
+            }
+        }
     }
 
     // create the handler and add it to the server's set of handlers
-    h := Handler{method, re, handlerValue}
+    h := Handler{method, uri, params, handlerValue, mw}
+    if srv.routes == nil {
+        srv.routes = newRouteNode()
+    }
+    if err := srv.routes.add(segments, h); err != nil {
+        log.Fatalf("Error in route %s %s: %s", method, uri, err)
+    }
     srv.Handlers = append(srv.Handlers, h)
 
+    return Route{srv, uri}
 }
 
-// Get adds a new handler for a GET request to the specified URI.
-func (srv *Server) Get (uri string, handler interface{}) {
-    srv.addRoute("GET", uri, handler)
+// Get adds a new handler for a GET request to the specified URI. Any
+// middleware provided is run, in order, before the handler. The
+// Route is returned so that it can be named.
+func (srv *Server) Get (uri string, handler interface{}, mw ...Middleware) Route {
+    return srv.addRoute("GET", uri, handler, mw)
 }
 
-// Post adds a new handler for a POST request to the specified URI.
-func (srv *Server) Post (uri string, handler interface{}) {
-    srv.addRoute("POST", uri, handler)
+// Post adds a new handler for a POST request to the specified URI. Any
+// middleware provided is run, in order, before the handler. The
+// Route is returned so that it can be named.
+func (srv *Server) Post (uri string, handler interface{}, mw ...Middleware) Route {
+    return srv.addRoute("POST", uri, handler, mw)
 }
 
-// Put adds a new handler for a PUT request to the specified URI.
-func (srv *Server) Put (uri string, handler interface{}) {
-    srv.addRoute("PUT", uri, handler)
+// Put adds a new handler for a PUT request to the specified URI. Any
+// middleware provided is run, in order, before the handler. The
+// Route is returned so that it can be named.
+func (srv *Server) Put (uri string, handler interface{}, mw ...Middleware) Route {
+    return srv.addRoute("PUT", uri, handler, mw)
 }
 
-// Delete adds a new handler for a DELETE request to the specified URI.
-func (srv *Server) Delete (uri string, handler interface{}) {
-    srv.addRoute("DELETE", uri, handler)
+// Patch adds a new handler for a PATCH request to the specified URI. Any
+// middleware provided is run, in order, before the handler. The
+// Route is returned so that it can be named.
+func (srv *Server) Patch (uri string, handler interface{}, mw ...Middleware) Route {
+    return srv.addRoute("PATCH", uri, handler, mw)
+}
+
+// Delete adds a new handler for a DELETE request to the specified URI. Any
+// middleware provided is run, in order, before the handler. The
+// Route is returned so that it can be named.
+func (srv *Server) Delete (uri string, handler interface{}, mw ...Middleware) Route {
+    return srv.addRoute("DELETE", uri, handler, mw)
 }
 
 /************************** WebContext functions *****************************/
@@ -183,18 +437,164 @@ func (srv *Server) Delete (uri string, handler interface{}) {
 // Write adds content to the HTTP response body. If no response code has been
 // set, 200 OK is automatically used.
 func (ctx *WebContext) Write (body []byte) {
+    if ctx.status == 0 {
+        ctx.status = 200
+    }
     ctx.conn.Write(body)
 }
 
+// Render adds the representation of v negotiated with the client, such as
+// JSON, to the HTTP response body. If v cannot be encoded, the request ends
+// with a 500 error instead, unless the response was already started.
+func (ctx *WebContext) Render (v interface{}) {
+    err := ctx.encode(contextWriter{ctx}, v)
+    if err == nil {
+        return
+    }
+
+    if ctx.status == 0 {
+        ctx.ServerError(err, "The response could not be encoded.")
+    } else {
+        log.Printf("%s could not encode the response: %s", ctx.RequestId, err)
+    }
+}
+
+// encode writes the representation of v negotiated with the client to w.
+func (ctx *WebContext) encode (w io.Writer, v interface{}) error {
+    // contexts created outside of ServeHTTP use the preferred encoding
+    if ctx.encoding.encode == nil {
+        return encodings[0].encode(w, v, ctx.pretty)
+    }
+
+    return ctx.encoding.encode(w, v, ctx.pretty)
+}
+
+// contextWriter is an io.Writer adding to the body of the response of a
+// WebContext, so that encoders can stream to it.
+type contextWriter struct {
+    ctx *WebContext
+}
+
+// Write adds p to the response body, setting the response code to 200 OK if
+// none has been set.
+func (w contextWriter) Write (p []byte) (int, error) {
+    if w.ctx.status == 0 {
+        w.ctx.status = 200
+    }
+
+    return w.ctx.conn.Write(p)
+}
+
 // Redirect sets the response code indicated and provides a Location header to
 // instruct the client to redirect.
 func (ctx *WebContext) Redirect ( code int, uri string ) {
+    ctx.status = code
     http.Redirect(ctx.conn, ctx.Request, uri, code)
 }
 
-// Abort ends the request with an status code and an optional body.
+// Abort ends the request with an status code and an optional body. No
+// further middleware or handler is run for the request.
 func (ctx *WebContext) Abort ( code int, body []byte ) {
-    ctx.conn.WriteHeader(code)
+    ctx.aborted = true
+    ctx.WriteHeader(code)
     ctx.Write(body)
 }
 
+// Param returns the value of the named parameter of the matched route, or ""
+// if there is no such parameter.
+func (ctx *WebContext) Param (name string) string {
+    return ctx.Params[name]
+}
+
+// Aborted reports whether the request was ended by Abort.
+func (ctx *WebContext) Aborted () bool {
+    return ctx.aborted
+}
+
+// Status returns the response code sent to the client, or 0 if nothing has
+// been sent yet.
+func (ctx *WebContext) Status () int {
+    return ctx.status
+}
+
+// WriteHeader sends the HTTP response header with the provided status code.
+// It must be called before Write if a status other than 200 OK is desired.
+func (ctx *WebContext) WriteHeader ( code int ) {
+    ctx.status = code
+    ctx.conn.WriteHeader(code)
+}
+
+// Error ends the request with an status code and a MessageError body
+// describing the problem.
+func (ctx *WebContext) Error ( code int, message string ) {
+    msg := MessageError{Code: code, Message: message, RequestId: ctx.RequestId}
+    var body bytes.Buffer
+    if err := ctx.encode(&body, msg); err != nil {
+        log.Printf("%s could not encode error: %s", ctx.RequestId, err)
+    }
+    ctx.Abort(code, body.Bytes())
+}
+
+// ServerError logs err, which kept the server from fulfilling the request, and
+// ends the request with a MessageError explaining what could not be done. The
+// status is 503 Service Unavailable if the database could not be reached and
+// 500 Internal Server Error otherwise.
+func (ctx *WebContext) ServerError ( err error, message string ) {
+    log.Printf("%s %s: %s: %s", ctx.Request.Method, ctx.Request.URL.Path, message, err)
+
+    if DbUnavailable(err) {
+        ctx.Error(503, "The database is unavailable. Please try again later.")
+        return
+    }
+
+    ctx.Error(500, message)
+}
+
+// chain runs the middleware in mw in order, each wrapping the rest, and then
+// final. Once the request is aborted, nothing further is run.
+func (ctx *WebContext) chain ( mw []Middleware, final func() ) {
+    if ctx.aborted {
+        return
+    }
+
+    if len(mw) == 0 {
+        final()
+        return
+    }
+
+    mw[0](ctx, func() {
+        ctx.chain(mw[1:], final)
+    })
+}
+
+// call invokes f, which runs middleware and handlers. A panic in f, including
+// one caused by a handler's parameters not matching its route, is logged
+// along with its stack and answered with a 500 MessageError, so that the
+// server keeps serving other requests.
+func (ctx *WebContext) call ( f func() ) {
+    defer func() {
+        if r := recover(); r != nil {
+            log.Printf("%s panic: %v\n%s", ctx.RequestId, r, debug.Stack())
+
+            // if the handler already started the response, it's too late to
+            // replace it with an error
+            if ctx.status == 0 {
+                ctx.Error(500, "The server encountered an unexpected error.")
+            }
+        }
+    }()
+
+    f()
+}
+
+// hook runs an after-response hook. As the response is already written, a
+// panic in the hook is only logged.
+func (ctx *WebContext) hook ( h Hook ) {
+    defer func() {
+        if r := recover(); r != nil {
+            log.Printf("%s panic in hook: %v\n%s", ctx.RequestId, r, debug.Stack())
+        }
+    }()
+
+    h(ctx)
+}
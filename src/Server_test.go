@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"     // marshal/unmarshal json
+	"errors"            // for failing handlers
+	"gospec"            // powers the specifications
+	. "gospec"          // ditto
+	"io"                // for dropped connections
+	"net"               // for unreachable databases
+	"net/http"          // for building requests
+	"net/http/httptest" // for recording responses without a network
+	"strconv"           // for comparing Content-Length
+)
+
+// serve runs a request against srv without going through the network and
+// returns the recorded response.
+func serve(srv *Server, method string, uri string) *httptest.ResponseRecorder {
+	request, err := http.NewRequest(method, "http://localhost:9999"+uri, nil)
+	if err != nil {
+		panic("Bug in test: cannot construct http.Request for " + uri + ": " + err.Error())
+	}
+
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+// ServerSpec specifies how the Server routes requests to its handlers.
+func ServerSpec(c gospec.Context) {
+	srv := NewServer()
+	srv.Get("/ok", func(ctx *WebContext) {
+		ctx.Write([]byte(`{"msg":"success"}`))
+	})
+	srv.Get("/panic", func(ctx *WebContext) {
+		panic("handler failure")
+	})
+	srv.Get("/unreachable", func(ctx *WebContext) {
+		ctx.ServerError(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, "The Provider could not be loaded.")
+	})
+	srv.Get("/dropped", func(ctx *WebContext) {
+		ctx.ServerError(io.EOF, "The Provider could not be loaded.")
+	})
+	srv.Get("/failed", func(ctx *WebContext) {
+		ctx.ServerError(errors.New("malformed hash"), "The Provider could not be loaded.")
+	})
+
+	c.Specify("answers a panicking handler with a 500 MessageError", func() {
+		response := serve(&srv, "GET", "/panic")
+		c.Expect(response.Code, Equals, 500)
+
+		var msg MessageError
+		err := json.Unmarshal(response.Body.Bytes(), &msg)
+		c.Expect(err, IsNil)
+		c.Expect(msg.Code, Equals, 500)
+		c.Expect(msg.RequestId, Not(Equals), "")
+		c.Expect(msg.RequestId, Equals, response.Header().Get("X-Request-Id"))
+	})
+
+	c.Specify("answers database failures with 503 and other errors with 500", func() {
+		c.Expect(serve(&srv, "GET", "/unreachable").Code, Equals, 503)
+		c.Expect(serve(&srv, "GET", "/dropped").Code, Equals, 503)
+
+		response := serve(&srv, "GET", "/failed")
+		c.Expect(response.Code, Equals, 500)
+
+		var msg MessageError
+		err := json.Unmarshal(response.Body.Bytes(), &msg)
+		c.Expect(err, IsNil)
+		c.Expect(msg.Code, Equals, 500)
+		c.Expect(msg.Message, Equals, "The Provider could not be loaded.")
+	})
+
+	c.Specify("keeps serving after a handler panics", func() {
+		serve(&srv, "GET", "/panic")
+		c.Expect(serve(&srv, "GET", "/ok").Code, Equals, 200)
+	})
+
+	c.Specify("gives every request a different ID", func() {
+		first := serve(&srv, "GET", "/ok").Header().Get("X-Request-Id")
+		second := serve(&srv, "GET", "/ok").Header().Get("X-Request-Id")
+		c.Expect(first, Not(Equals), second)
+	})
+}
+
+// MethodSpec specifies how requests for a known URI with an unsupported
+// method are answered.
+func MethodSpec(c gospec.Context) {
+	srv := NewServer()
+	srv.Get("/providers", func(ctx *WebContext) {})
+	srv.Post("/providers", func(ctx *WebContext) {})
+	srv.Get("/providers/{id:int}", func(ctx *WebContext) {})
+	srv.Delete("/providers/{id:int}", func(ctx *WebContext) {})
+
+	c.Specify("returns 405 with the allowed methods", func() {
+		response := serve(&srv, "DELETE", "/providers")
+		c.Expect(response.Code, Equals, 405)
+		c.Expect(response.Header().Get("Allow"), Equals, "GET, HEAD, POST, OPTIONS")
+
+		var msg MessageError
+		json.Unmarshal(response.Body.Bytes(), &msg)
+		c.Expect(msg.Code, Equals, 405)
+	})
+
+	c.Specify("answers OPTIONS with the allowed methods", func() {
+		response := serve(&srv, "OPTIONS", "/providers/1001")
+		c.Expect(response.Code, Equals, 204)
+		c.Expect(response.Header().Get("Allow"), Equals, "GET, HEAD, DELETE, OPTIONS")
+	})
+
+	c.Specify("still returns 404 for unknown URIs", func() {
+		c.Expect(serve(&srv, "DELETE", "/publishers").Code, Equals, 404)
+		c.Expect(serve(&srv, "OPTIONS", "/publishers").Code, Equals, 404)
+	})
+}
+
+// HeadSpec specifies how HEAD requests are answered from GET handlers.
+func HeadSpec(c gospec.Context) {
+	body := `{"msg":"success","results":[]}`
+	srv := NewServer()
+	srv.Get("/providers", func(ctx *WebContext) {
+		ctx.Header.Set("ETag", `"v1"`)
+		ctx.Write([]byte(body))
+	})
+
+	c.Specify("returns the GET headers without the body", func() {
+		response := serve(&srv, "HEAD", "/providers")
+		c.Expect(response.Code, Equals, 200)
+		c.Expect(response.Body.Len(), Equals, 0)
+		c.Expect(response.Header().Get("ETag"), Equals, `"v1"`)
+		c.Expect(response.Header().Get("Content-Length"), Equals, strconv.Itoa(len(body)))
+	})
+
+	c.Specify("returns the GET errors without the body", func() {
+		response := serve(&srv, "HEAD", "/publishers")
+		c.Expect(response.Code, Equals, 404)
+		c.Expect(response.Body.Len(), Equals, 0)
+	})
+}
+
+// RouteSpec specifies how routes with named parameters are matched.
+func RouteSpec(c gospec.Context) {
+	var positional, named []string
+	srv := NewServer()
+	srv.Get("/users/{uid:int}/texts/{tid:int}", func(ctx *WebContext, uid string, tid string) {
+		positional = []string{uid, tid}
+		named = []string{ctx.Param("uid"), ctx.Param("tid")}
+	})
+	srv.Get("/tags/{tag}", func(ctx *WebContext) {
+		named = []string{ctx.Param("tag")}
+	})
+	srv.Get("/files/v1.0", func(ctx *WebContext) {
+		named = []string{"literal"}
+	})
+
+	c.Specify("passes parameters as arguments and through Param", func() {
+		c.Expect(serve(&srv, "GET", "/users/12/texts/34").Code, Equals, 200)
+		c.Expect(positional, ContainsExactly, Values("12", "34"))
+		c.Expect(named, ContainsExactly, Values("12", "34"))
+	})
+
+	c.Specify("matches untyped parameters up to the next slash", func() {
+		c.Expect(serve(&srv, "GET", "/tags/go-lang").Code, Equals, 200)
+		c.Expect(named, ContainsExactly, Values("go-lang"))
+		c.Expect(serve(&srv, "GET", "/tags/go/lang").Code, Equals, 404)
+	})
+
+	c.Specify("does not match parameters violating their type", func() {
+		c.Expect(serve(&srv, "GET", "/users/jsmith/texts/34").Code, Equals, 404)
+	})
+
+	c.Specify("matches the rest of the route literally", func() {
+		c.Expect(serve(&srv, "GET", "/files/v1.0").Code, Equals, 200)
+		c.Expect(serve(&srv, "GET", "/files/v100").Code, Equals, 404)
+	})
+
+	c.Specify("prefers the most specific route", func() {
+		var matched string
+		srv := NewServer()
+		srv.Get("/users/me", func(ctx *WebContext) { matched = "static" })
+		srv.Get("/users/{id:int}", func(ctx *WebContext) { matched = "int " + ctx.Param("id") })
+		srv.Get("/users/{name}", func(ctx *WebContext) { matched = "param " + ctx.Param("name") })
+		srv.Get("/users/{path...}", func(ctx *WebContext) { matched = "wildcard " + ctx.Param("path") })
+		srv.Get("/users/{name}/texts", func(ctx *WebContext) { matched = "texts " + ctx.Param("name") })
+
+		serve(&srv, "GET", "/users/me")
+		c.Expect(matched, Equals, "static")
+		serve(&srv, "GET", "/users/12")
+		c.Expect(matched, Equals, "int 12")
+		serve(&srv, "GET", "/users/jsmith")
+		c.Expect(matched, Equals, "param jsmith")
+		serve(&srv, "GET", "/users/jsmith/notes/1")
+		c.Expect(matched, Equals, "wildcard jsmith/notes/1")
+
+		// a more specific segment that leads nowhere gives way
+		serve(&srv, "GET", "/users/me/texts")
+		c.Expect(matched, Equals, "texts me")
+	})
+
+	c.Specify("matches wildcards against non-empty paths only", func() {
+		srv := NewServer()
+		srv.Get("/files/{path...}", func(ctx *WebContext) {})
+		c.Expect(serve(&srv, "GET", "/files/a/b.txt").Code, Equals, 200)
+		c.Expect(serve(&srv, "GET", "/files/").Code, Equals, 404)
+		c.Expect(serve(&srv, "GET", "/files").Code, Equals, 404)
+	})
+
+	c.Specify("refuses two routes for the same method and paths", func() {
+		root := newRouteNode()
+		segments, _, _ := parseRoute("/users/{id:int}")
+		c.Expect(root.add(segments, Handler{Method: "GET"}), IsNil)
+		c.Expect(root.add(segments, Handler{Method: "PUT"}), IsNil)
+		segments, _, _ = parseRoute("/users/{uid:int}")
+		c.Expect(root.add(segments, Handler{Method: "GET"}), Not(IsNil))
+	})
+
+	c.Specify("rejects malformed routes", func() {
+		_, _, err := parseRoute("/users/{id:float}")
+		c.Expect(err, Not(IsNil))
+		_, _, err = parseRoute("/users/{id}/{id}")
+		c.Expect(err, Not(IsNil))
+		_, _, err = parseRoute("/users/{id")
+		c.Expect(err, Not(IsNil))
+		_, _, err = parseRoute("/files/{name}.json")
+		c.Expect(err, Not(IsNil))
+		_, _, err = parseRoute("/files/{path...}/raw")
+		c.Expect(err, Not(IsNil))
+	})
+
+	c.Specify("converts parameters to integers", func() {
+		n, err := Params{"id": "1001"}.Int("id")
+		c.Expect(err, IsNil)
+		c.Expect(n, Equals, int64(1001))
+		_, err = Params{"id": "1001"}.Int("uid")
+		c.Expect(err, Not(IsNil))
+	})
+}
+
+// GroupSpec specifies how route groups apply their prefix and middleware.
+func GroupSpec(c gospec.Context) {
+	var trace []string
+	record := func(name string) Middleware {
+		return func(ctx *WebContext, next func()) {
+			trace = append(trace, name)
+			next()
+		}
+	}
+
+	srv := NewServer()
+	v1 := srv.Group("/v1.0", record("v1"))
+	v1.Get("/", func(ctx *WebContext) {})
+	v1.Get("/providers/{id:int}", func(ctx *WebContext, id string) {
+		trace = append(trace, "provider "+id)
+	}, record("route"))
+	admin := v1.Group("/admin/", record("admin"))
+	admin.Delete("/users/{id:int}", func(ctx *WebContext) {
+		trace = append(trace, "user "+ctx.Param("id"))
+	})
+	v1.Get("/users", func(ctx *WebContext) {
+		trace = append(trace, "users")
+	})
+
+	c.Specify("serves routes under the prefix only", func() {
+		c.Expect(serve(&srv, "GET", "/v1.0/providers/7").Code, Equals, 200)
+		c.Expect(serve(&srv, "GET", "/providers/7").Code, Equals, 404)
+		c.Expect(serve(&srv, "GET", "/v1.0").Code, Equals, 200)
+	})
+
+	c.Specify("runs the group middleware before that of the route", func() {
+		trace = nil
+		serve(&srv, "GET", "/v1.0/providers/7")
+		c.Expect(trace, ContainsInOrder, Values("v1", "route", "provider 7"))
+	})
+
+	c.Specify("nests groups within groups", func() {
+		trace = nil
+		c.Expect(serve(&srv, "DELETE", "/v1.0/admin/users/7").Code, Equals, 200)
+		c.Expect(trace, ContainsInOrder, Values("v1", "admin", "user 7"))
+
+		// routes of the outer group do not run the inner group's middleware
+		trace = nil
+		serve(&srv, "GET", "/v1.0/users")
+		c.Expect(trace, ContainsInOrder, Values("v1", "users"))
+	})
+}
+
+// MiddlewareSpec specifies how middleware and hooks wrap the handlers.
+func MiddlewareSpec(c gospec.Context) {
+	var trace []string
+	record := func(name string) Middleware {
+		return func(ctx *WebContext, next func()) {
+			trace = append(trace, name+" before")
+			next()
+			trace = append(trace, name+" after")
+		}
+	}
+	deny := func(ctx *WebContext, next func()) {
+		ctx.Error(403, "Denied.")
+	}
+
+	srv := NewServer()
+	srv.Use(record("global"))
+	srv.After(func(ctx *WebContext) {
+		trace = append(trace, "hook")
+	})
+	srv.Get("/open", func(ctx *WebContext) {
+		trace = append(trace, "handler")
+		ctx.Write([]byte(`{"msg":"success"}`))
+	}, record("route"))
+	srv.Get("/closed", func(ctx *WebContext) {
+		trace = append(trace, "handler")
+	}, deny, record("route"))
+
+	c.Specify("runs global middleware, route middleware, the handler, then hooks", func() {
+		response := serve(&srv, "GET", "/open")
+		c.Expect(response.Code, Equals, 200)
+		c.Expect(trace, ContainsInOrder, Values(
+			"global before", "route before", "handler", "route after", "global after", "hook"))
+	})
+
+	c.Specify("stops the chain when middleware aborts but still runs hooks", func() {
+		response := serve(&srv, "GET", "/closed")
+		c.Expect(response.Code, Equals, 403)
+		c.Expect(trace, ContainsInOrder, Values("global before", "global after", "hook"))
+	})
+
+	c.Specify("runs global middleware and hooks for unknown routes", func() {
+		response := serve(&srv, "GET", "/unknown")
+		c.Expect(response.Code, Equals, 404)
+		c.Expect(trace, ContainsInOrder, Values("global before", "global after", "hook"))
+	})
+}
@@ -0,0 +1,305 @@
+package main
+
+// Stem returns the stem of the lower-case English word, as found by the Porter
+// stemming algorithm, so that e.g. "libraries" and "library" both become
+// "librari". Words of two letters or fewer are returned unchanged.
+//
+// See M.F. Porter, "An algorithm for suffix stripping", Program 14(3), 1980.
+// This follows the reference implementation, including its departures from the
+// paper: "bli" becomes "ble" and "logi" becomes "log".
+func Stem (word string) string {
+    if len(word) <= 2 {
+        return word
+    }
+
+    s := stemmer{b: []byte(word), k: len(word) - 1}
+    s.step1ab()
+    if s.k > 0 {
+        s.step1c()
+        s.step2()
+        s.step3()
+        s.step4()
+        s.step5()
+    }
+
+    return string(s.b[:s.k + 1])
+}
+
+// stemmer holds a word while its suffixes are stripped.
+type stemmer struct {
+    // b holds the word; only b[0] to b[k] are part of it.
+    b []byte
+
+    // k is the offset of the last letter of the word.
+    k int
+
+    // j is the offset of the last letter before a suffix found by ends.
+    j int
+}
+
+// cons reports whether b[i] is a consonant. A "y" is a consonant at the start
+// of a word or after a vowel.
+func (s *stemmer) cons (i int) bool {
+    switch s.b[i] {
+    case 'a', 'e', 'i', 'o', 'u':
+        return false
+    case 'y':
+        return i == 0 || ! s.cons(i - 1)
+    }
+
+    return true
+}
+
+// m returns the number of vowel-consonant sequences in b[0] to b[j], which
+// the paper calls the measure of the stem.
+func (s *stemmer) m () int {
+    n, i := 0, 0
+
+    // skip the leading consonants
+    for ; i <= s.j && s.cons(i); i++ {
+    }
+
+    for i <= s.j {
+        // skip vowels
+        for ; i <= s.j && ! s.cons(i); i++ {
+        }
+        if i > s.j {
+            break
+        }
+
+        // skip consonants, completing a sequence
+        for ; i <= s.j && s.cons(i); i++ {
+        }
+        n++
+    }
+
+    return n
+}
+
+// vowelInStem reports whether b[0] to b[j] contains a vowel.
+func (s *stemmer) vowelInStem () bool {
+    for i := 0; i <= s.j; i++ {
+        if ! s.cons(i) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// doubleC reports whether b[i-1] and b[i] are the same consonant.
+func (s *stemmer) doubleC (i int) bool {
+    if i < 1 || s.b[i] != s.b[i - 1] {
+        return false
+    }
+
+    return s.cons(i)
+}
+
+// cvc reports whether b[i-2], b[i-1], and b[i] are consonant, vowel, and
+// consonant, the last not being "w", "x", or "y", as at the end of "hop".
+func (s *stemmer) cvc (i int) bool {
+    if i < 2 || ! s.cons(i) || s.cons(i - 1) || ! s.cons(i - 2) {
+        return false
+    }
+
+    switch s.b[i] {
+    case 'w', 'x', 'y':
+        return false
+    }
+
+    return true
+}
+
+// ends reports whether the word ends with suffix, setting j to the offset
+// before it if so.
+func (s *stemmer) ends (suffix string) bool {
+    l := len(suffix)
+    if l > s.k + 1 || string(s.b[s.k - l + 1:s.k + 1]) != suffix {
+        return false
+    }
+    s.j = s.k - l
+
+    return true
+}
+
+// setTo replaces the suffix after j with replacement.
+func (s *stemmer) setTo (replacement string) {
+    s.b = append(s.b[:s.j + 1], replacement...)
+    s.k = s.j + len(replacement)
+}
+
+// r replaces the suffix after j with replacement if the measure of the stem
+// is positive.
+func (s *stemmer) r (replacement string) {
+    if s.m() > 0 {
+        s.setTo(replacement)
+    }
+}
+
+// step1ab removes plurals and "-ed" or "-ing", e.g. "caresses" to "caress",
+// "ponies" to "poni", "agreed" to "agree", and "hopping" to "hop".
+func (s *stemmer) step1ab () {
+    if s.b[s.k] == 's' {
+        switch {
+        case s.ends("sses"):
+            s.k -= 2
+        case s.ends("ies"):
+            s.setTo("i")
+        case s.b[s.k - 1] != 's':
+            s.k--
+        }
+    }
+
+    if s.ends("eed") {
+        if s.m() > 0 {
+            s.k--
+        }
+    } else if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+        s.k = s.j
+        switch {
+        case s.ends("at"):
+            s.setTo("ate")
+        case s.ends("bl"):
+            s.setTo("ble")
+        case s.ends("iz"):
+            s.setTo("ize")
+        case s.doubleC(s.k):
+            switch s.b[s.k] {
+            case 'l', 's', 'z':
+            default:
+                s.k--
+            }
+        default:
+            s.j = s.k
+            if s.m() == 1 && s.cvc(s.k) {
+                s.setTo("e")
+            }
+        }
+    }
+}
+
+// step1c turns a final "y" into "i" when there is another vowel in the stem.
+func (s *stemmer) step1c () {
+    if s.ends("y") && s.vowelInStem() {
+        s.b[s.k] = 'i'
+    }
+}
+
+// replaceSuffix replaces the first of the suffixes the word ends with, which
+// come in pairs of suffix and replacement, as r does.
+func (s *stemmer) replaceSuffix (pairs ...string) {
+    for i := 0; i < len(pairs); i += 2 {
+        if s.ends(pairs[i]) {
+            s.r(pairs[i + 1])
+            return
+        }
+    }
+}
+
+// step2 maps double suffixes to single ones, e.g. "-ization" to "-ize".
+func (s *stemmer) step2 () {
+    switch s.b[s.k - 1] {
+    case 'a':
+        s.replaceSuffix("ational", "ate", "tional", "tion")
+    case 'c':
+        s.replaceSuffix("enci", "ence", "anci", "ance")
+    case 'e':
+        s.replaceSuffix("izer", "ize")
+    case 'l':
+        s.replaceSuffix("bli", "ble", "alli", "al", "entli", "ent", "eli", "e", "ousli", "ous")
+    case 'o':
+        s.replaceSuffix("ization", "ize", "ation", "ate", "ator", "ate")
+    case 's':
+        s.replaceSuffix("alism", "al", "iveness", "ive", "fulness", "ful", "ousness", "ous")
+    case 't':
+        s.replaceSuffix("aliti", "al", "iviti", "ive", "biliti", "ble")
+    case 'g':
+        s.replaceSuffix("logi", "log")
+    }
+}
+
+// step3 handles "-ic-", "-full", "-ness", and the like.
+func (s *stemmer) step3 () {
+    switch s.b[s.k] {
+    case 'e':
+        s.replaceSuffix("icate", "ic", "ative", "", "alize", "al")
+    case 'i':
+        s.replaceSuffix("iciti", "ic")
+    case 'l':
+        s.replaceSuffix("ical", "ic", "ful", "")
+    case 's':
+        s.replaceSuffix("ness", "")
+    }
+}
+
+// step4 removes "-ant", "-ence", and the like from stems with a measure
+// greater than one.
+func (s *stemmer) step4 () {
+    var suffixes []string
+    switch s.b[s.k - 1] {
+    case 'a':
+        suffixes = []string{"al"}
+    case 'c':
+        suffixes = []string{"ance", "ence"}
+    case 'e':
+        suffixes = []string{"er"}
+    case 'i':
+        suffixes = []string{"ic"}
+    case 'l':
+        suffixes = []string{"able", "ible"}
+    case 'n':
+        suffixes = []string{"ant", "ement", "ment", "ent"}
+    case 'o':
+        // "-ion" only goes after "s" or "t"
+        if s.ends("ion") && s.j >= 0 && (s.b[s.j] == 's' || s.b[s.j] == 't') {
+            break
+        }
+        suffixes = []string{"ou"}
+    case 's':
+        suffixes = []string{"ism"}
+    case 't':
+        suffixes = []string{"ate", "iti"}
+    case 'u':
+        suffixes = []string{"ous"}
+    case 'v':
+        suffixes = []string{"ive"}
+    case 'z':
+        suffixes = []string{"ize"}
+    default:
+        return
+    }
+
+    if suffixes != nil {
+        found := false
+        for _, suffix := range suffixes {
+            if s.ends(suffix) {
+                found = true
+                break
+            }
+        }
+        if ! found {
+            return
+        }
+    }
+
+    if s.m() > 1 {
+        s.k = s.j
+    }
+}
+
+// step5 removes a final "-e" and turns "-ll" into "-l" in stems with a large
+// enough measure.
+func (s *stemmer) step5 () {
+    s.j = s.k
+    if s.b[s.k] == 'e' {
+        a := s.m()
+        if a > 1 || a == 1 && ! s.cvc(s.k - 1) {
+            s.k--
+        }
+    }
+
+    if s.b[s.k] == 'l' && s.doubleC(s.k) && s.m() > 1 {
+        s.k--
+    }
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "godis"
+    "strconv"
+    "time"          // for creation and update timestamps
+)
+
+// Text represents a document written by a User.
+type Text struct {
+
+    // Identifier is the unique ID of the text.
+    Identifier string `json:"-" xml:"-" db:"-"`
+
+    // Owner is the ID of the User who wrote the text.
+    Owner string `json:"owner" xml:"owner"`
+
+    // Title is a friendly representation of the text.
+    Title string `json:"title" xml:"title" search:"3"`
+
+    // Body is the content of the text.
+    Body string `json:"body" xml:"body" db:",omitempty" search:"1"`
+
+    // Created is the time at which the text was created.
+    Created time.Time `json:"created" xml:"created"`
+
+    // Updated is the time at which the text was last changed.
+    Updated time.Time `json:"updated" xml:"updated"`
+
+    // db is an internal pointer to the database connection.
+    db *godis.Client `json:"-" xml:"-"`
+}
+
+// init registers Texts as a Model.
+func init() {
+    RegisterModel("text:", func(db *godis.Client, id string) (DbObject, error) {
+        t, err := LoadText(db, id)
+        if err != nil {
+            return nil, err
+        }
+        return t, nil
+    })
+}
+
+// NewText creates a new Text owned by the User with the given ID.
+func NewText( db *godis.Client, owner string, title string ) (*Text, error) {
+    var t Text
+
+    // get the next available text ID
+    i64, err := db.Incr("nxTextId")
+    if err != nil {
+        return nil, err
+    }
+
+    // build the Text
+    t.Identifier = strconv.FormatInt(i64, 10)
+    t.Owner = owner
+    t.Title = title
+    t.Created = time.Now().UTC()
+    t.Updated = t.Created
+    t.db = db
+
+    // return a pointer to the Text
+    return &t, nil
+}
+
+// LoadText fetches the Text with the given ID from the database. If no such
+// Text exists, ErrNotFound is returned.
+func LoadText( db *godis.Client, id string ) (*Text, error) {
+    var t Text
+    t.Identifier = id
+    t.db = db
+
+    if err := LoadHash(db, t.GetKey(), &t); err != nil {
+        return nil, err
+    }
+
+    // return a pointer to the Text
+    return &t, nil
+}
+
+// Touch sets the Updated time of the Text to now.
+func (t *Text) Touch() {
+    t.Updated = time.Now().UTC()
+}
+
+// GetKey returns the database key for this Text.
+func (t *Text) GetKey() string {
+    return "text:" + t.Identifier
+}
+
+// IndexKey returns the key of the sorted set indexing the Owner's Texts.
+func (t *Text) IndexKey() string {
+    return "idx:User:" + t.Owner + ":Text"
+}
+
+// References returns the key of the User who owns this Text, which is
+// deleted along with it.
+func (t *Text) References() []string {
+    return []string{(&User{Identifier: t.Owner}).GetKey()}
+}
+
+// Db returns a pointer to the database client.
+func (t *Text) Db() *godis.Client {
+    return t.db
+}
+
+// Id returns the ID of this Text.
+func (t *Text) Id() string {
+    return t.Identifier
+}
+
+// Label returns the Title of this Text.
+func (t *Text) Label() string {
+    return t.Title
+}
+
+// Route returns the name of the route serving this Text and its parameters.
+func (t *Text) Route() (string, []string) {
+    return "text", []string{t.Owner, t.Identifier}
+}
+
+// GetTexts returns one Page of the Texts of the User with the given ID
+// selected and sorted by query, along with the total number of them. The view
+// says what more of each Text to include; their URIs are built from the routes
+// of srv.
+func GetTexts (db *godis.Client, srv *Server, owner string, query Query, page Page, view View) ([]Resource, int64, error) {
+    var texts []Resource
+
+    // fetch the Texts
+    var idx Text
+    idx.Owner = owner
+    idx.Identifier = "*"
+    entries, total, err := QueryIndex(db, idx.IndexKey(), idx.GetKey(), query, page)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // loop through all results, creating resources
+    for _, e := range entries {
+        // build the Text
+        var t Text
+        t.Identifier = e.Id
+        t.Owner = owner
+        t.Title = e.Label
+
+        // create a resource from the text, loading the whole text if the
+        // view asks for more than its title
+        r := NewResource(srv, &t)
+        if ! view.Empty() {
+            full, err := LoadText(db, e.Id)
+            if err == ErrNotFound {
+                // deleted since the index was read
+                continue
+            } else if err != nil {
+                return nil, 0, err
+            }
+            if err := view.Apply(&r, full); err != nil {
+                return nil, 0, err
+            }
+        }
+
+        // add it to the array to return
+        texts = append(texts, r)
+    }
+
+    // return the array of texts
+    return texts, total, nil
+}
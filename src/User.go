@@ -0,0 +1,288 @@
+package main
+
+import (
+    "errors"
+    "godis"
+    "regexp"        // for validating usernames
+    "strconv"
+)
+
+// User represents a member of Citeplasm who holds Texts and Resources.
+type User struct {
+
+    // Identifier is the unique ID of the user.
+    Identifier string `json:"-" xml:"-" db:"-"`
+
+    // Username is the unique name the user authenticates with.
+    Username string `json:"username" xml:"username"`
+
+    // DisplayName is the friendly name shown for the user.
+    DisplayName string `json:"name" xml:"name" db:",omitempty"`
+
+    // Email is the address at which the user can be contacted.
+    Email string `json:"email" xml:"email" db:",omitempty"`
+
+    // Secret is the key used to sign the user's requests. It is accepted when
+    // registering or updating a user but is never returned by the API.
+    Secret string `json:"secret,omitempty" xml:"secret,omitempty"`
+
+    // db is an internal pointer to the database connection.
+    db *godis.Client `json:"-" xml:"-"`
+}
+
+// init registers Users as a Model.
+func init() {
+    RegisterModel("user:", func(db *godis.Client, id string) (DbObject, error) {
+        u, err := LoadUser(db, id)
+        if err != nil {
+            return nil, err
+        }
+        return u, nil
+    })
+}
+
+// ErrUsernameTaken is returned when saving a User whose Username already
+// belongs to another User.
+var ErrUsernameTaken = errors.New("username is already taken")
+
+// validUsername matches the usernames that are safe to use within the
+// Authorization header and the database indexes.
+var validUsername = regexp.MustCompile("^[A-Za-z0-9_.-]+$")
+
+// NewUser creates a new User.
+func NewUser( db *godis.Client, username string ) (*User, error) {
+    var u User
+
+    // get the next available user ID
+    i64, err := db.Incr("nxUserId")
+    if err != nil {
+        return nil, err
+    }
+
+    // build the User
+    u.Identifier = strconv.FormatInt(i64, 10)
+    u.Username = username
+    u.db = db
+
+    // return a pointer to the User
+    return &u, nil
+}
+
+// LoadUser fetches the User with the given ID from the database. If no such
+// User exists, ErrNotFound is returned.
+func LoadUser( db *godis.Client, id string ) (*User, error) {
+    var u User
+    u.Identifier = id
+    u.db = db
+
+    if err := LoadHash(db, u.GetKey(), &u); err != nil {
+        return nil, err
+    }
+
+    // return a pointer to the User
+    return &u, nil
+}
+
+// LoadUserByUsername fetches the User with the given Username from the
+// database. If no such User exists, ErrNotFound is returned.
+func LoadUserByUsername( db *godis.Client, username string ) (*User, error) {
+    // look up the ID in the "usernames" hash of username -> ID
+    id, err := db.Hget("usernames", username)
+    if err != nil {
+        return nil, err
+    }
+    if id == nil {
+        return nil, ErrNotFound
+    }
+
+    return LoadUser(db, id.String())
+}
+
+// ValidUsername reports whether the username may be registered.
+func ValidUsername( username string ) bool {
+    return validUsername.MatchString(username)
+}
+
+// SaveUser persists the User, claiming its Username so no other User may
+// register it. If the Username belongs to another User, ErrUsernameTaken is
+// returned.
+func SaveUser( u *User ) error {
+    return ReplaceUser(nil, u)
+}
+
+// ReplaceUser saves the User u in place of old, which may be nil for a new
+// User. The Secret of old is kept if u has none. If u has a new Username, it
+// is claimed and the old one released; if it belongs to another User,
+// ErrUsernameTaken is returned.
+func ReplaceUser( old *User, u *User ) error {
+    if old != nil && u.Secret == "" {
+        u.Secret = old.Secret
+    }
+
+    // claim the new username before anything else, so that two Users cannot
+    // both take it
+    renamed := old == nil || old.Username != u.Username
+    if err := ClaimUsername(u); err != nil {
+        return err
+    }
+
+    // the hash and the usernames are then written together; see QueueRecord
+    var err error
+    if old == nil {
+        err = SaveHashes(u)
+    } else {
+        err = ReplaceHash(old, u)
+    }
+
+    // give the new username back if the User could not be saved
+    if err != nil && renamed {
+        u.db.Hdel("usernames", u.Username)
+    }
+
+    return err
+}
+
+// ClaimUsername records the User's Username as belonging to it. If the
+// Username belongs to another User, ErrUsernameTaken is returned.
+func ClaimUsername( u *User ) error {
+    // claim the username if nobody else has
+    claimed, err := u.db.Hsetnx("usernames", u.Username, u.Identifier)
+    if err != nil {
+        return err
+    }
+
+    // if it was already claimed, it must have been by this User
+    if ! claimed {
+        owner, err := u.db.Hget("usernames", u.Username)
+        if err != nil {
+            return err
+        }
+        if owner.String() != u.Identifier {
+            return ErrUsernameTaken
+        }
+    }
+
+    return nil
+}
+
+// DeleteUser removes the User from the database along with its Texts and
+// Citations, and releases its Username, all in one transaction.
+func DeleteUser( u *User ) error {
+    // a Text or Citation saved while they are gathered makes the transaction
+    // fail with ErrReferenced, in which case they are gathered again
+    for attempt := 0; attempt < writeAttempts; attempt++ {
+        objs := []DbObject{u}
+
+        // gather everything that refers to the User, i.e. that it owns
+        r, err := u.db.Smembers(referrersKey(u.GetKey()))
+        if err != nil {
+            return err
+        }
+        for _, key := range r.StringArray() {
+            obj, err := loadKey(u.db, key)
+            if err == ErrNotFound {
+                continue
+            } else if err != nil {
+                return err
+            }
+            objs = append(objs, obj)
+        }
+
+        if err := DeleteHashes(objs...); err != ErrReferenced {
+            return err
+        }
+    }
+
+    return errors.New("cannot delete " + u.GetKey() + ": it was changed by too many other requests")
+}
+
+// QueueRecord records the Username as belonging to the User, as part of the
+// transaction in pipe that saves it. It must have been claimed with
+// ClaimUsername first.
+func (u *User) QueueRecord(pipe *godis.PipeClient) {
+    pipe.Hset("usernames", u.Username, u.Identifier)
+}
+
+// QueueUnrecord releases the Username, as part of the transaction in pipe that
+// deletes the User.
+func (u *User) QueueUnrecord(pipe *godis.PipeClient) {
+    pipe.Hdel("usernames", u.Username)
+}
+
+// Public returns a copy of the User that is safe to return to clients, i.e.
+// without its Secret.
+func (u *User) Public() *User {
+    p := *u
+    p.Secret = ""
+    return &p
+}
+
+// GetKey returns the database key for this User.
+func (u *User) GetKey() string {
+    return "user:" + u.Identifier
+}
+
+// Db returns a pointer to the database client.
+func (u *User) Db() *godis.Client {
+    return u.db
+}
+
+// Id returns the ID of this User.
+func (u *User) Id() string {
+    return u.Identifier
+}
+
+// Label returns the Username of this User.
+func (u *User) Label() string {
+    return u.Username
+}
+
+// Route returns the name of the route serving this User and its parameters.
+func (u *User) Route() (string, []string) {
+    return "user", []string{u.Identifier}
+}
+
+// GetUsers returns one Page of the Users selected and sorted by query, along
+// with the total number of them. The view says what more of each User to
+// include; their URIs are built from the routes of srv.
+func GetUsers (db *godis.Client, srv *Server, query Query, page Page, view View) ([]Resource, int64, error) {
+    var users []Resource
+
+    // fetch the Users
+    var idx User
+    idx.Identifier = "*"
+    entries, total, err := QueryIndex(db, "idx:User", idx.GetKey(), query, page)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    // loop through all results, creating resources
+    for _, e := range entries {
+        // build the User
+        var u User
+        u.Identifier = e.Id
+        u.Username = e.Label
+
+        // create a resource from the user, loading the whole user if the
+        // view asks for more than its username; its secret is never shown
+        r := NewResource(srv, &u)
+        if ! view.Empty() {
+            full, err := LoadUser(db, e.Id)
+            if err == ErrNotFound {
+                // deleted since the index was read
+                continue
+            } else if err != nil {
+                return nil, 0, err
+            }
+            if err := view.Apply(&r, full.Public()); err != nil {
+                return nil, 0, err
+            }
+        }
+
+        // add it to the array to return
+        users = append(users, r)
+    }
+
+    // return the array of users
+    return users, total, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "reflect"       // for finding the fields of a model
+    "sort"
+    "strings"
+)
+
+// View is the part of each object a collection endpoint returns besides its
+// label and URI, as requested through the "fields" and "embed" query
+// parameters, e.g. "?fields=name,icon" or "?embed=full".
+type View struct {
+    // Fields are the names of the fields to include, as in the JSON
+    // representation of the objects.
+    Fields []string
+
+    // Full is set if the complete objects are to be embedded.
+    Full bool
+}
+
+// Empty reports whether the View asks for nothing besides labels and URIs,
+// in which case the objects need not be loaded.
+func (v View) Empty () bool {
+    return len(v.Fields) == 0 && ! v.Full
+}
+
+// ParseView reads the View requested through the "fields" and "embed" query
+// parameters, allowing only the given field names; see JsonFields. Unknown
+// fields and embeddings are reported as a ValidationError.
+func ParseView (ctx *WebContext, allowed []string) (View, error) {
+    var view View
+    query := ctx.Request.URL.Query()
+
+    switch embed := query.Get("embed"); embed {
+    case "":
+    case "full":
+        view.Full = true
+    default:
+        return view, ValidationError("The embed parameter must be \"full\".")
+    }
+
+    fields := query.Get("fields")
+    if fields == "" {
+        return view, nil
+    }
+
+    seen := make(map[string]bool)
+    for _, name := range strings.Split(fields, ",") {
+        name = strings.TrimSpace(name)
+        if name == "" || seen[name] {
+            continue
+        }
+        if ! containsString(allowed, name) {
+            return view, ValidationError("Unknown field \"" + name + "\"; the fields are " + strings.Join(allowed, ", ") + ".")
+        }
+        seen[name] = true
+        view.Fields = append(view.Fields, name)
+    }
+
+    return view, nil
+}
+
+// JsonFields returns the names of the fields in the JSON representation of
+// obj, a struct or a pointer to one, in order, leaving out those in except.
+func JsonFields (obj interface{}, except ...string) []string {
+    t := reflect.TypeOf(obj)
+    if t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+
+    var names []string
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+
+        // unexported fields are never written
+        if f.PkgPath != "" {
+            continue
+        }
+
+        name := f.Tag.Get("json")
+        if comma := strings.Index(name, ","); comma >= 0 {
+            name = name[:comma]
+        }
+        if name == "-" {
+            continue
+        }
+        if name == "" {
+            name = f.Name
+        }
+
+        if ! containsString(except, name) {
+            names = append(names, name)
+        }
+    }
+
+    return names
+}
+
+// Apply adds what the View asks for of obj, the object referred to by r, to
+// r: the fields requested and, for a full View, obj itself.
+func (v View) Apply (r *Resource, obj interface{}) error {
+    if v.Full {
+        r.Embedded = obj
+    }
+    if len(v.Fields) == 0 {
+        return nil
+    }
+
+    // pick the fields out of the JSON representation of obj, so that they
+    // are named and written as in the object itself
+    j, err := json.Marshal(obj)
+    if err != nil {
+        return err
+    }
+    var all map[string]json.RawMessage
+    if err := json.Unmarshal(j, &all); err != nil {
+        return err
+    }
+
+    r.Fields = nil
+    for _, name := range v.Fields {
+        // fields left out of the object when empty are left out here too
+        if value, ok := all[name]; ok {
+            r.Fields = append(r.Fields, newField(name, value))
+        }
+    }
+
+    return nil
+}
+
+// Field is one field of an object included in a Resource by a View.
+type Field struct {
+    // XMLName is named after the field, so that in XML the field is an
+    // element such as <icon>.
+    XMLName xml.Name
+
+    // Value is the JSON representation of the field.
+    Value json.RawMessage `xml:"-"`
+
+    // Text is the value of the field in XML: strings as they are, and other
+    // values, such as lists, in their JSON representation.
+    Text string `xml:",chardata"`
+}
+
+// newField creates the Field with the given name and JSON value.
+func newField (name string, value json.RawMessage) Field {
+    f := Field{XMLName: xml.Name{Local: name}, Value: value, Text: string(value)}
+
+    var s string
+    if json.Unmarshal(value, &s) == nil {
+        f.Text = s
+    }
+
+    return f
+}
+
+// Fields are the fields of an object included in a Resource by a View. In JSON
+// they are written as an object of values by name,
+//
+//     "fields": {"name": "OpenLibrary.org", "icon": "http://..."}
+//
+// and in XML as elements named after the fields.
+type Fields []Field
+
+// MarshalJSON writes the Fields as an object of values by name.
+func (fields Fields) MarshalJSON () ([]byte, error) {
+    obj := make(map[string]json.RawMessage)
+    for _, f := range fields {
+        obj[f.XMLName.Local] = f.Value
+    }
+
+    return json.Marshal(obj)
+}
+
+// UnmarshalJSON reads Fields written by MarshalJSON, sorted by name.
+func (fields *Fields) UnmarshalJSON (data []byte) error {
+    var obj map[string]json.RawMessage
+    if err := json.Unmarshal(data, &obj); err != nil {
+        return err
+    }
+
+    names := make([]string, 0, len(obj))
+    for name := range obj {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    *fields = nil
+    for _, name := range names {
+        *fields = append(*fields, newField(name, obj[name]))
+    }
+
+    return nil
+}
+
+// Get returns the JSON representation of the field with the given name, or
+// nil if there is none.
+func (fields Fields) Get (name string) json.RawMessage {
+    for _, f := range fields {
+        if f.XMLName.Local == name {
+            return f.Value
+        }
+    }
+
+    return nil
+}
+
+// containsString reports whether s is one of list.
+func containsString (list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+
+    return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"gospec"
+	. "gospec"
+	"net/http"
+	"strings"
+)
+
+// ViewSpec specifies how the fields and embedding of list results are
+// requested and represented.
+func ViewSpec(c gospec.Context) {
+	parse := func(query string) (View, error) {
+		request, _ := http.NewRequest("GET", "/providers?"+query, nil)
+		return ParseView(&WebContext{Request: request}, JsonFields(&Provider{}))
+	}
+	provider := &Provider{Identifier: "1001", Name: "OpenLibrary.org", Icon: "http://openlibrary.org/icon.png"}
+	srv := NewServer()
+	srv.Get("/v1.0/providers/{id:int}", func(ctx *WebContext) {}).Name("provider")
+
+	c.Specify("lists the fields of the JSON representation", func() {
+		c.Expect(JsonFields(&Provider{}), ContainsExactly, Values("name", "icon", "logo", "descr"))
+		c.Expect(JsonFields(&User{}, "secret"), ContainsExactly, Values("username", "name", "email"))
+	})
+
+	c.Specify("asks for nothing more by default", func() {
+		view, err := parse("")
+		c.Expect(err, IsNil)
+		c.Expect(view.Empty(), IsTrue)
+	})
+
+	c.Specify("parses the fields and embedding", func() {
+		view, err := parse("fields=name,%20icon,name&embed=full")
+		c.Expect(err, IsNil)
+		c.Expect(view.Fields, ContainsExactly, Values("name", "icon"))
+		c.Expect(view.Full, IsTrue)
+	})
+
+	c.Specify("refuses unknown fields and embeddings", func() {
+		_, err := parse("fields=name,secret")
+		c.Expect(err, Not(IsNil))
+		c.Expect(strings.Contains(err.Error(), "secret"), IsTrue)
+
+		_, err = parse("embed=some")
+		c.Expect(err, Not(IsNil))
+	})
+
+	c.Specify("projects the requested fields in JSON", func() {
+		r := NewResource(&srv, provider)
+		c.Expect(View{Fields: []string{"icon", "name"}}.Apply(&r, provider), IsNil)
+
+		j, _ := json.Marshal(r)
+		var got struct {
+			Fields   map[string]string `json:"fields"`
+			Embedded interface{}       `json:"embedded"`
+		}
+		c.Expect(json.Unmarshal(j, &got), IsNil)
+		c.Expect(len(got.Fields), Equals, 2)
+		c.Expect(got.Fields["icon"], Equals, provider.Icon)
+		c.Expect(got.Embedded, IsNil)
+
+		var back Resource
+		c.Expect(json.Unmarshal(j, &back), IsNil)
+		c.Expect(string(back.Fields.Get("name")), Equals, `"OpenLibrary.org"`)
+	})
+
+	c.Specify("projects the requested fields and embeds objects in XML", func() {
+		r := NewResource(&srv, provider)
+		c.Expect(View{Fields: []string{"icon"}, Full: true}.Apply(&r, provider), IsNil)
+
+		x, _ := xml.Marshal(r)
+		var got struct {
+			Icon     string   `xml:"fields>icon"`
+			Embedded Provider `xml:"embedded"`
+		}
+		c.Expect(xml.Unmarshal(x, &got), IsNil)
+		c.Expect(got.Icon, Equals, provider.Icon)
+		c.Expect(got.Embedded.Name, Equals, provider.Name)
+	})
+}
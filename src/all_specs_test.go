@@ -9,6 +9,20 @@ import (
 func TestAllSpecs(t *testing.T) {
     r := gospec.NewRunner()
     r.AddSpec(MainSpec)
+    r.AddSpec(HashSpec)
+    r.AddSpec(PatchSpec)
+    r.AddSpec(ServerSpec)
+    r.AddSpec(RouteSpec)
+    r.AddSpec(MethodSpec)
+    r.AddSpec(HeadSpec)
+    r.AddSpec(MiddlewareSpec)
+    r.AddSpec(GroupSpec)
+    r.AddSpec(NegotiateSpec)
+    r.AddSpec(PrettySpec)
+    r.AddSpec(LinkSpec)
+    r.AddSpec(ViewSpec)
+    r.AddSpec(QuerySpec)
+    r.AddSpec(SearchSpec)
     FlushDb()
     LoadFixtures()
     gospec.MainGoTest(r, t)
@@ -1,64 +1,914 @@
 package main
 
+import (
+    "flag"
+    "log"
+    "strings"
+)
+
+// reindex tells the server to rebuild the database indexes and exit rather
+// than serve requests.
+var reindex = flag.Bool("reindex", false, "rebuild the idx:* and search:* indexes from the stored objects and exit")
+
 // main is the entry point to the REST API server.
 func main() {
+    flag.Parse()
+
+    // fail now, rather than on the first request, if the database
+    // configuration is invalid
+    DbConnect()
+
+    if *reindex {
+        if err := Reindex(DbConnect()); err != nil {
+            log.Fatalf("Could not rebuild the indexes: %s", err)
+        }
+        log.Println("Rebuilt the indexes.")
+        return
+    }
 
     var server = NewServer()
 
+    // log every request, allowing browsers anywhere to use the API
+    server.Use(LogRequests, Cors("*"))
+    server.After(LogResponses)
+
         server.Get("/", func(ctx *WebContext) {
-                // return a set of available resources
-		ctx.Header.Set("Content-type", "application/json")
-		providers := Resource{"providers", "/v1.0/providers"}
-		resources := Resource{"resources", "/v1.0/resources"}
-		msg := MessageSuccess{"success", []Resource{providers, resources}}
-		ctx.Write(msg.Json())
-	})
+                // return a set of available resources, linked from the routes
+                // serving them
+		var results []Resource
+		for _, name := range []string{"providers", "users"} {
+			uri := ctx.Href(name)
+			results = append(results, Resource{Label: name, Uri: uri, Links: Links{Link{Rel: "self", Href: uri}}})
+		}
+		msg := MessageSuccess{Msg: "success", Results: results, Total: int64(len(results))}
+		msg.Links = Links{
+			ctx.Link("self", "GET", "root"),
+			ctx.TemplateLink("provider", "GET", "provider"),
+			ctx.TemplateLink("user", "GET", "user"),
+			Link{Rel: "search", Href: ctx.Href("search") + "?q={q}", Templated: true},
+		}
+		ctx.Render(msg)
+	}).Name("root")
+
+        // every resource is served under the version of the API
+	v1 := server.Group(ApiVersion)
 
         // GET /providers
-	server.Get("/providers", func(ctx *WebContext) {
-                // ensure the user properly authenticated
-		if ! IsAuthenticated(ctx) {
+	v1.Get("/providers", func(ctx *WebContext) {
+                // determine which page of providers is wanted
+		page, err := ParsePage(ctx)
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+                // determine how much of each provider is wanted, and which
+                // providers in which order
+		view, err := ParseView(ctx, JsonFields(&Provider{}))
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+		query, err := ParseQuery(ctx, QueryFields(&Provider{}))
+		if err != nil {
+			ctx.Error(400, err.Error())
 			return
 		}
 
                 // connect to the DB
                 db := DbConnect()
 
-                // fetch a list of providers
-                providers := GetProviders(db)
+                // fetch a page of providers
+                providers, total, err := GetProviders(db, ctx.server, query, page, view)
+		if err != nil {
+			ctx.ServerError(err, "The providers could not be listed.")
+			return
+		}
 
                 // create a response message for the providers and write it out
-                msg := MessageSuccess{"success", providers}
-                ctx.Write(msg.Json())
+                msg := NewPageMessage(ctx, page, providers, total)
+                msg.Links = append(msg.Links, ctx.Link("create", "POST", "providers"), ctx.TemplateLink("item", "GET", "provider"))
+                ctx.Render(msg)
+	}, RequireAuth).Name("providers")
+
+        // POST /providers
+	v1.Post("/providers", func(ctx *WebContext) {
+		// parse the Provider from the request body
+		var input Provider
+		if err := Unmarshal(ctx.Request.Body, &input); err != nil {
+			ctx.Error(400, "The request body must be a JSON Provider.")
+			return
+		}
+		if input.Name == "" {
+			ctx.Error(400, "A Provider must have a name.")
+			return
+		}
+
+                db := DbConnect()
+
+                // create the Provider with the next available ID and persist it
+		p, err := NewProvider(db, input.Name)
+		if err != nil {
+			ctx.ServerError(err, "The Provider could not be saved.")
+			return
+		}
+		p.Icon = input.Icon
+		p.Logo = input.Logo
+		p.Description = input.Description
+		if err := SaveHashes(p); err != nil {
+			ctx.ServerError(err, "The Provider could not be saved.")
+			return
+		}
+
+                // point the client to the newly-created Provider
+		ctx.Header.Set("Location", ctx.ObjectHref(p))
+		ctx.WriteHeader(201)
+		msg := MessageObject{Msg: "success", Result: p, Links: ctx.ObjectLinks("provider", "providers", p.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth)
+
+        // GET /providers/id
+	v1.Get("/providers/{id:int}", func(ctx *WebContext, id string) {
+                db := DbConnect()
+
+		p, err := LoadProvider(db, id)
+		if err == ErrNotFound {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Provider could not be loaded.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: p, Links: ctx.ObjectLinks("provider", "providers", p.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth).Name("provider")
+
+        // PUT /providers/id
+	v1.Put("/providers/{id:int}", func(ctx *WebContext, id string) {
+		// parse the replacement Provider from the request body
+		var input Provider
+		if err := Unmarshal(ctx.Request.Body, &input); err != nil {
+			ctx.Error(400, "The request body must be a JSON Provider.")
+			return
+		}
+		if input.Name == "" {
+			ctx.Error(400, "A Provider must have a name.")
+			return
+		}
+
+                db := DbConnect()
+
+                // the Provider must already exist to be replaced
+		old, err := LoadProvider(db, id)
+		if err == ErrNotFound {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Provider could not be loaded.")
+			return
+		}
+
+                // replace the old Provider entirely so that fields left out of
+                // the replacement and its old index entry do not linger
+		p := &input
+		p.Identifier = old.Identifier
+		p.db = db
+		if err := ReplaceHash(old, p); err != nil {
+			ctx.ServerError(err, "The Provider could not be saved.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: p, Links: ctx.ObjectLinks("provider", "providers", p.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth)
+
+        // PATCH /providers/id
+	v1.Patch("/providers/{id:int}", func(ctx *WebContext, id string) {
+                db := DbConnect()
+
+                // the Provider must already exist to be patched
+		old, err := LoadProvider(db, id)
+		if err == ErrNotFound {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Provider could not be loaded.")
+			return
+		}
+
+                // apply the patch to the stored Provider
+		var input Provider
+		if ! ReadPatch(ctx, old, &input) {
+			return
+		}
+		if input.Name == "" {
+			ctx.Error(422, "A Provider must have a name.")
+			return
+		}
+
+		p := &input
+		p.Identifier = old.Identifier
+		p.db = db
+		if err := ReplaceHash(old, p); err != nil {
+			ctx.ServerError(err, "The Provider could not be saved.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: p, Links: ctx.ObjectLinks("provider", "providers", p.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth)
+
+        // DELETE /providers/id
+	v1.Delete("/providers/{id:int}", func(ctx *WebContext, id string) {
+                db := DbConnect()
+
+		p, err := LoadProvider(db, id)
+		if err == ErrNotFound {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Provider could not be loaded.")
+			return
+		}
+
+                // Resources must not be left referencing a missing Provider, or
+                // they could no longer be replaced or patched
+		if err := DeleteHashes(p); err == ErrReferenced {
+			ctx.Error(409, "The Provider is still referenced by Resources.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Provider could not be deleted.")
+			return
+		}
+
+		ctx.WriteHeader(204)
+	}, RequireAuth)
+
+        // GET /users
+	v1.Get("/users", func(ctx *WebContext) {
+		page, err := ParsePage(ctx)
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+		view, err := ParseView(ctx, JsonFields(&User{}, "secret"))
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+		query, err := ParseQuery(ctx, QueryFields(&User{}, "secret"))
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+                db := DbConnect()
+
+		users, total, err := GetUsers(db, ctx.server, query, page, view)
+		if err != nil {
+			ctx.ServerError(err, "The users could not be listed.")
+			return
+		}
+
+		msg := NewPageMessage(ctx, page, users, total)
+		msg.Links = append(msg.Links, ctx.Link("create", "POST", "users"), ctx.TemplateLink("item", "GET", "user"))
+		ctx.Render(msg)
+	}, RequireAuth).Name("users")
+
+        // POST /users
+        // Registration is open, so no authentication is required.
+	v1.Post("/users", func(ctx *WebContext) {
+		// parse the User from the request body
+		var input User
+		if err := Unmarshal(ctx.Request.Body, &input); err != nil {
+			ctx.Error(400, "The request body must be a JSON User.")
+			return
+		}
+		if ! ValidUsername(input.Username) {
+			ctx.Error(400, "A User must have a username of letters, digits, '_', '.', or '-'.")
+			return
+		}
+		if input.Secret == "" {
+			ctx.Error(400, "A User must have a secret.")
+			return
+		}
+
+                db := DbConnect()
+
+                // create the User with the next available ID and persist it
+		u, err := NewUser(db, input.Username)
+		if err != nil {
+			ctx.ServerError(err, "The User could not be saved.")
+			return
+		}
+		u.DisplayName = input.DisplayName
+		u.Email = input.Email
+		u.Secret = input.Secret
+		if err := SaveUser(u); err == ErrUsernameTaken {
+			ctx.Error(409, "The username is already taken.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The User could not be saved.")
+			return
+		}
+
+                // point the client to the newly-created User
+		ctx.Header.Set("Location", ctx.ObjectHref(u))
+		ctx.WriteHeader(201)
+		msg := MessageObject{Msg: "success", Result: u.Public(), Links: ctx.ObjectLinks("user", "users", u.Identifier)}
+		ctx.Render(msg)
 	})
 
-        // TODO: POST /providers
+        // GET /users/id
+	v1.Get("/users/{id:int}", func(ctx *WebContext, id string) {
+                db := DbConnect()
+
+		u, err := LoadUser(db, id)
+		if err == ErrNotFound {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The User could not be loaded.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: u.Public(), Links: ctx.ObjectLinks("user", "users", u.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth).Name("user")
+
+        // PUT /users/id
+	v1.Put("/users/{id:int}", func(ctx *WebContext, id string) {
+                // users may only change their own profile
+		if ctx.User.Identifier != id {
+			ctx.Error(403, "You may only modify your own profile.")
+			return
+		}
+
+		// parse the replacement User from the request body
+		var input User
+		if err := Unmarshal(ctx.Request.Body, &input); err != nil {
+			ctx.Error(400, "The request body must be a JSON User.")
+			return
+		}
+		if ! ValidUsername(input.Username) {
+			ctx.Error(400, "A User must have a username of letters, digits, '_', '.', or '-'.")
+			return
+		}
+
+		updateUser(ctx, ctx.User, &input)
+	}, RequireAuth)
+
+        // PATCH /users/id
+	v1.Patch("/users/{id:int}", func(ctx *WebContext, id string) {
+                // users may only change their own profile
+		if ctx.User.Identifier != id {
+			ctx.Error(403, "You may only modify your own profile.")
+			return
+		}
+
+                // apply the patch to the public profile, so that the secret
+                // can be set but not read
+		old := ctx.User
+		var input User
+		if ! ReadPatch(ctx, old.Public(), &input) {
+			return
+		}
+		if ! ValidUsername(input.Username) {
+			ctx.Error(422, "A User must have a username of letters, digits, '_', '.', or '-'.")
+			return
+		}
+
+		updateUser(ctx, old, &input)
+	}, RequireAuth)
+
+        // DELETE /users/id
+	v1.Delete("/users/{id:int}", func(ctx *WebContext, id string) {
+                // users may only delete themselves
+		if ctx.User.Identifier != id {
+			ctx.Error(403, "You may only delete your own profile.")
+			return
+		}
+
+		if err := DeleteUser(ctx.User); err != nil {
+			ctx.ServerError(err, "The User could not be deleted.")
+			return
+		}
+
+		ctx.WriteHeader(204)
+	}, RequireAuth)
+
+        // GET /users/id/texts
+	v1.Get("/users/{uid:int}/texts", func(ctx *WebContext, uid string) {
+		page, err := ParsePage(ctx)
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+		view, err := ParseView(ctx, JsonFields(&Text{}))
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+		query, err := ParseQuery(ctx, QueryFields(&Text{}))
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
 
-        // TODO: GET /providers/id
-        // TODO: PUT /providers/id
-        // TODO: DELETE /providers/id
+                db := DbConnect()
 
-	// TODO: GET /users
-	// TODO: POST /users
+                // the owning User must exist
+		if _, err := LoadUser(db, uid); err == ErrNotFound {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The User could not be loaded.")
+			return
+		}
 
-	// TODO: GET /users/id
-	// TODO: PUT /users/id
-	// TODO: DELETE /users/id
+		texts, total, err := GetTexts(db, ctx.server, uid, query, page, view)
+		if err != nil {
+			ctx.ServerError(err, "The texts could not be listed.")
+			return
+		}
 
-	// TODO: GET /users/id/texts
-	// TODO: POST /users/id/texts
+		msg := NewPageMessage(ctx, page, texts, total)
+		msg.Links = append(msg.Links, ctx.Link("create", "POST", "texts", uid), ctx.TemplateLink("item", "GET", "text"))
+		ctx.Render(msg)
+	}, RequireAuth).Name("texts")
 
-	// TODO: GET /users/id/texts/id
-	// TODO: PUT /users/id/texts/id
-	// TODO: DELETE /users/id/texts/id
+        // POST /users/id/texts
+	v1.Post("/users/{uid:int}/texts", func(ctx *WebContext, uid string) {
+                // only the owner may write their texts
+		if ctx.User.Identifier != uid {
+			ctx.Error(403, "You may only write your own texts.")
+			return
+		}
 
-	// TODO: GET /users/id/resources
-	// TODO: POST /users/id/resources
+		// parse the Text from the request body
+		var input Text
+		if err := Unmarshal(ctx.Request.Body, &input); err != nil {
+			ctx.Error(400, "The request body must be a JSON Text.")
+			return
+		}
+		if input.Title == "" {
+			ctx.Error(400, "A Text must have a title.")
+			return
+		}
+
+                db := DbConnect()
+
+                // create the Text with the next available ID and persist it
+		t, err := NewText(db, uid, input.Title)
+		if err != nil {
+			ctx.ServerError(err, "The Text could not be saved.")
+			return
+		}
+		t.Body = input.Body
+		if err := SaveHashes(t); err != nil {
+			ctx.ServerError(err, "The Text could not be saved.")
+			return
+		}
 
-	// TODO: GET /users/id/resources/id
-	// TODO: PUT /users/id/resources/id
-	// TODO: DELETE /users/id/resources/id
+                // point the client to the newly-created Text
+		ctx.Header.Set("Location", ctx.ObjectHref(t))
+		ctx.WriteHeader(201)
+		msg := MessageObject{Msg: "success", Result: t, Links: ctx.ObjectLinks("text", "texts", t.Owner, t.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth)
+
+        // GET /users/id/texts/id
+	v1.Get("/users/{uid:int}/texts/{tid:int}", func(ctx *WebContext, uid string, tid string) {
+                db := DbConnect()
+
+                // the Text must exist and belong to the User in the URI
+		t, err := LoadText(db, tid)
+		if err == ErrNotFound || (err == nil && t.Owner != uid) {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Text could not be loaded.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: t, Links: ctx.ObjectLinks("text", "texts", t.Owner, t.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth).Name("text")
+
+        // PUT /users/id/texts/id
+	v1.Put("/users/{uid:int}/texts/{tid:int}", func(ctx *WebContext, uid string, tid string) {
+                // only the owner may write their texts
+		if ctx.User.Identifier != uid {
+			ctx.Error(403, "You may only write your own texts.")
+			return
+		}
+
+		// parse the replacement Text from the request body
+		var input Text
+		if err := Unmarshal(ctx.Request.Body, &input); err != nil {
+			ctx.Error(400, "The request body must be a JSON Text.")
+			return
+		}
+		if input.Title == "" {
+			ctx.Error(400, "A Text must have a title.")
+			return
+		}
+
+                db := DbConnect()
+
+                // the Text must exist and belong to the User in the URI
+		old, err := LoadText(db, tid)
+		if err == ErrNotFound || (err == nil && old.Owner != uid) {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Text could not be loaded.")
+			return
+		}
+
+                // the owner and creation time cannot be replaced
+		t := &input
+		t.Identifier = old.Identifier
+		t.Owner = old.Owner
+		t.Created = old.Created
+		t.db = db
+		t.Touch()
+		if err := ReplaceHash(old, t); err != nil {
+			ctx.ServerError(err, "The Text could not be saved.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: t, Links: ctx.ObjectLinks("text", "texts", t.Owner, t.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth)
+
+        // PATCH /users/id/texts/id
+	v1.Patch("/users/{uid:int}/texts/{tid:int}", func(ctx *WebContext, uid string, tid string) {
+                // only the owner may write their texts
+		if ctx.User.Identifier != uid {
+			ctx.Error(403, "You may only write your own texts.")
+			return
+		}
+
+                db := DbConnect()
+
+                // the Text must exist and belong to the User in the URI
+		old, err := LoadText(db, tid)
+		if err == ErrNotFound || (err == nil && old.Owner != uid) {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Text could not be loaded.")
+			return
+		}
+
+                // apply the patch to the stored Text
+		var input Text
+		if ! ReadPatch(ctx, old, &input) {
+			return
+		}
+		if input.Title == "" {
+			ctx.Error(422, "A Text must have a title.")
+			return
+		}
+
+                // the owner and creation time cannot be patched
+		t := &input
+		t.Identifier = old.Identifier
+		t.Owner = old.Owner
+		t.Created = old.Created
+		t.db = db
+		t.Touch()
+		if err := ReplaceHash(old, t); err != nil {
+			ctx.ServerError(err, "The Text could not be saved.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: t, Links: ctx.ObjectLinks("text", "texts", t.Owner, t.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth)
+
+        // DELETE /users/id/texts/id
+	v1.Delete("/users/{uid:int}/texts/{tid:int}", func(ctx *WebContext, uid string, tid string) {
+                // only the owner may delete their texts
+		if ctx.User.Identifier != uid {
+			ctx.Error(403, "You may only delete your own texts.")
+			return
+		}
+
+                db := DbConnect()
+
+		t, err := LoadText(db, tid)
+		if err == ErrNotFound || (err == nil && t.Owner != uid) {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Text could not be loaded.")
+			return
+		}
+
+		if err := DeleteHashes(t); err != nil {
+			ctx.ServerError(err, "The Text could not be deleted.")
+			return
+		}
+
+		ctx.WriteHeader(204)
+	}, RequireAuth)
+
+        // GET /users/id/resources
+	v1.Get("/users/{uid:int}/resources", func(ctx *WebContext, uid string) {
+		page, err := ParsePage(ctx)
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+		view, err := ParseView(ctx, JsonFields(&Citation{}))
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+		query, err := ParseQuery(ctx, QueryFields(&Citation{}))
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+                db := DbConnect()
+
+                // the owning User must exist
+		if _, err := LoadUser(db, uid); err == ErrNotFound {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The User could not be loaded.")
+			return
+		}
+
+		citations, total, err := GetCitations(db, ctx.server, uid, query, page, view)
+		if err != nil {
+			ctx.ServerError(err, "The resources could not be listed.")
+			return
+		}
+
+		msg := NewPageMessage(ctx, page, citations, total)
+		msg.Links = append(msg.Links, ctx.Link("create", "POST", "resources", uid), ctx.TemplateLink("item", "GET", "resource"))
+		ctx.Render(msg)
+	}, RequireAuth).Name("resources")
+
+        // POST /users/id/resources
+	v1.Post("/users/{uid:int}/resources", func(ctx *WebContext, uid string) {
+                // only the owner may write their resources
+		if ctx.User.Identifier != uid {
+			ctx.Error(403, "You may only write your own resources.")
+			return
+		}
+
+		// parse the Citation from the request body
+		var input Citation
+		if err := Unmarshal(ctx.Request.Body, &input); err != nil {
+			ctx.Error(400, "The request body must be a JSON Resource.")
+			return
+		}
+
+                db := DbConnect()
+
+                // create the Citation with the next available ID
+		c, err := NewCitation(db, uid, input.Title)
+		if err != nil {
+			ctx.ServerError(err, "The Resource could not be saved.")
+			return
+		}
+		c.Authors = input.Authors
+		c.Url = input.Url
+		c.Identifiers = input.Identifiers
+		c.Provider = input.Provider
+
+                // ensure it is complete and its Provider exists before saving
+		if err := c.Validate(); err != nil {
+			if verr, ok := err.(ValidationError); ok {
+				ctx.Error(400, verr.Error())
+			} else {
+				ctx.ServerError(err, "The Resource could not be validated.")
+			}
+			return
+		}
+		if err := SaveHashes(c); err == DanglingError(c.providerKey()) {
+			ctx.Error(400, "The referenced Provider does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Resource could not be saved.")
+			return
+		}
+
+                // point the client to the newly-created Citation
+		ctx.Header.Set("Location", ctx.ObjectHref(c))
+		ctx.WriteHeader(201)
+		msg := MessageObject{Msg: "success", Result: c, Links: ctx.ObjectLinks("resource", "resources", c.Owner, c.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth)
+
+        // GET /users/id/resources/id
+	v1.Get("/users/{uid:int}/resources/{rid:int}", func(ctx *WebContext, uid string, rid string) {
+                db := DbConnect()
+
+                // the Citation must exist and belong to the User in the URI
+		c, err := LoadCitation(db, rid)
+		if err == ErrNotFound || (err == nil && c.Owner != uid) {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Resource could not be loaded.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: c, Links: ctx.ObjectLinks("resource", "resources", c.Owner, c.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth).Name("resource")
+
+        // PUT /users/id/resources/id
+	v1.Put("/users/{uid:int}/resources/{rid:int}", func(ctx *WebContext, uid string, rid string) {
+                // only the owner may write their resources
+		if ctx.User.Identifier != uid {
+			ctx.Error(403, "You may only write your own resources.")
+			return
+		}
+
+		// parse the replacement Citation from the request body
+		var input Citation
+		if err := Unmarshal(ctx.Request.Body, &input); err != nil {
+			ctx.Error(400, "The request body must be a JSON Resource.")
+			return
+		}
+
+                db := DbConnect()
+
+                // the Citation must exist and belong to the User in the URI
+		old, err := LoadCitation(db, rid)
+		if err == ErrNotFound || (err == nil && old.Owner != uid) {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Resource could not be loaded.")
+			return
+		}
+
+                // the owner cannot be replaced
+		c := &input
+		c.Identifier = old.Identifier
+		c.Owner = old.Owner
+		c.db = db
+		if err := c.Validate(); err != nil {
+			if verr, ok := err.(ValidationError); ok {
+				ctx.Error(400, verr.Error())
+			} else {
+				ctx.ServerError(err, "The Resource could not be validated.")
+			}
+			return
+		}
+		if err := ReplaceHash(old, c); err == DanglingError(c.providerKey()) {
+			ctx.Error(400, "The referenced Provider does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Resource could not be saved.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: c, Links: ctx.ObjectLinks("resource", "resources", c.Owner, c.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth)
+
+        // PATCH /users/id/resources/id
+	v1.Patch("/users/{uid:int}/resources/{rid:int}", func(ctx *WebContext, uid string, rid string) {
+                // only the owner may write their resources
+		if ctx.User.Identifier != uid {
+			ctx.Error(403, "You may only write your own resources.")
+			return
+		}
+
+                db := DbConnect()
+
+                // the Citation must exist and belong to the User in the URI
+		old, err := LoadCitation(db, rid)
+		if err == ErrNotFound || (err == nil && old.Owner != uid) {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Resource could not be loaded.")
+			return
+		}
+
+                // apply the patch to the stored Citation
+		var input Citation
+		if ! ReadPatch(ctx, old, &input) {
+			return
+		}
+
+                // the owner cannot be patched
+		c := &input
+		c.Identifier = old.Identifier
+		c.Owner = old.Owner
+		c.db = db
+		if err := c.Validate(); err != nil {
+			if verr, ok := err.(ValidationError); ok {
+				ctx.Error(422, verr.Error())
+			} else {
+				ctx.ServerError(err, "The Resource could not be validated.")
+			}
+			return
+		}
+		if err := ReplaceHash(old, c); err == DanglingError(c.providerKey()) {
+			ctx.Error(422, "The referenced Provider does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Resource could not be saved.")
+			return
+		}
+
+		msg := MessageObject{Msg: "success", Result: c, Links: ctx.ObjectLinks("resource", "resources", c.Owner, c.Identifier)}
+		ctx.Render(msg)
+	}, RequireAuth)
+
+        // DELETE /users/id/resources/id
+	v1.Delete("/users/{uid:int}/resources/{rid:int}", func(ctx *WebContext, uid string, rid string) {
+                // only the owner may delete their resources
+		if ctx.User.Identifier != uid {
+			ctx.Error(403, "You may only delete your own resources.")
+			return
+		}
+
+                db := DbConnect()
+
+		c, err := LoadCitation(db, rid)
+		if err == ErrNotFound || (err == nil && c.Owner != uid) {
+			ctx.Error(404, "Resource does not exist.")
+			return
+		} else if err != nil {
+			ctx.ServerError(err, "The Resource could not be loaded.")
+			return
+		}
+
+		if err := DeleteHashes(c); err != nil {
+			ctx.ServerError(err, "The Resource could not be deleted.")
+			return
+		}
+
+		ctx.WriteHeader(204)
+	}, RequireAuth)
+
+        // GET /search?q=words
+	v1.Get("/search", func(ctx *WebContext) {
+		q := ctx.Request.URL.Query().Get("q")
+		if strings.TrimSpace(q) == "" {
+			ctx.Error(400, "The q parameter must give the words to search for.")
+			return
+		}
+
+		page, err := ParsePage(ctx)
+		if err != nil {
+			ctx.Error(400, err.Error())
+			return
+		}
+
+                db := DbConnect()
+
+                // find the best matches among providers, texts, and resources
+		results, total, err := Search(db, ctx.server, q, page)
+		if err != nil {
+			ctx.ServerError(err, "The search could not be completed.")
+			return
+		}
+
+		ctx.Render(NewPageMessage(ctx, page, results, total))
+	}, RequireAuth).Name("search")
 
         // start the server on all addresses on port 9999
         server.Start(":9999")
 }
+
+// updateUser saves u, read from a PUT or PATCH request, in place of old, the
+// authenticated User, and renders the result.
+func updateUser(ctx *WebContext, old *User, u *User) {
+	u.Identifier = old.Identifier
+	u.db = DbConnect()
+
+        // the secret is only changed when a new one is provided, and a new
+        // username is claimed in the same transaction that saves the User
+	if err := ReplaceUser(old, u); err == ErrUsernameTaken {
+		ctx.Error(409, "The username is already taken.")
+		return
+	} else if err != nil {
+		ctx.ServerError(err, "The User could not be saved.")
+		return
+	}
+
+	msg := MessageObject{Msg: "success", Result: u.Public(), Links: ctx.ObjectLinks("user", "users", u.Identifier)}
+	ctx.Render(msg)
+}
@@ -5,12 +5,16 @@ import (
 	"crypto/md5"      // for authentication generation
 	"encoding/base64" // for authentication generation
 	"encoding/json"   // marshal/unmarshal json
+	"encoding/xml"    // unmarshal xml
 	"fmt"             // printing errors, etc.
 	"gospec"          // powers the specifications
 	. "gospec"        // ditto
 	"hash"            // for authentication generation
 	"io/ioutil"       // parsing response bodies
 	"net/http"        // used to run queries against the main server
+	"net/url"         // query parameters
+	"strconv"         // large offsets
+	"strings"         // request bodies
 	"time"            // Date header
 )
 
@@ -33,10 +37,28 @@ func LoadFixtures() error {
     c.Set("nxTextId", 1000)
 
     // create a few dummy providers
-    p1 := NewProvider(c, "National Library of Medicine")
-    p2 := NewProvider(c, "FactCheck.org")
-    p3 := NewProvider(c, "OpenLibrary.org")
-    SaveHashes(p1, p2, p3)
+    var providers []DbObject
+    for _, name := range []string{"National Library of Medicine", "FactCheck.org", "OpenLibrary.org"} {
+        p, err := NewProvider(c, name)
+        if err != nil {
+            return err
+        }
+        providers = append(providers, p)
+    }
+    if err := SaveHashes(providers...); err != nil {
+        return err
+    }
+
+    // create the user the specs authenticate as
+    u, err := NewUser(c, "username")
+    if err != nil {
+        return err
+    }
+    u.DisplayName = "Test User"
+    u.Secret = "password"
+    if err := SaveUser(u); err != nil {
+        return err
+    }
 
     return nil
 }
@@ -54,10 +76,10 @@ func FlushDb() error {
 }
 
 // createRequest is a basic http.NewRequest wrapper with error handling.
-func createRequest(method string, url string) *http.Request {
-	request, err := http.NewRequest("GET", "http://localhost:9999"+url, nil)
+func createRequest(method string, url string, body string) *http.Request {
+	request, err := http.NewRequest(method, "http://localhost:9999"+url, strings.NewReader(body))
 	if err != nil {
-		panic(fmt.Sprintf("Bug in test: cannot construct http.Request from method=GET, url=%q: %s", url, err))
+		panic(fmt.Sprintf("Bug in test: cannot construct http.Request from method=%s, url=%q: %s", method, url, err))
 	}
 	currentTime := time.Now().UTC().Format(time.RFC1123)
 	request.Header.Add("Accept", "application/json")
@@ -71,7 +93,7 @@ func do(request *http.Request) *http.Response {
 	client := new(http.Client)
 	response, err := client.Do(request)
 	if err != nil {
-		panic(fmt.Sprintf("Bug in test: cannot run request: GET %s\nError: %v", request.URL.Raw, err))
+		panic(fmt.Sprintf("Bug in test: cannot run request: %s %s\nError: %v", request.Method, request.URL.Raw, err))
 	}
 
 	return response
@@ -91,7 +113,7 @@ func getResponseBody(response *http.Response) string {
 // GetRequest simply performs a GET on the specified URL with the Content-type
 // set as "application/json".
 func GetRequest(url string) ProcessedResponse {
-	request := createRequest("GET", url)
+	request := createRequest("GET", url, "")
 	response := do(request)
 	body := getResponseBody(response)
 
@@ -99,7 +121,7 @@ func GetRequest(url string) ProcessedResponse {
 }
 
 // CreateSignature generates a GDS authentication signature
-func CreateSignature(verb string, body string, date string, uri string) string {
+func CreateSignature(verb string, body string, date string, uri string, secret string) string {
 	var (
 		signature string
 		bodyHash  hash.Hash
@@ -117,7 +139,7 @@ func CreateSignature(verb string, body string, date string, uri string) string {
 	signature += uri + "\n"
 
 	// create the hmac
-	sigHmac = hmac.NewSHA1([]byte("password"))
+	sigHmac = hmac.NewSHA1([]byte(secret))
 	sigHmac.Write([]byte(signature))
 
 	return base64.StdEncoding.EncodeToString(sigHmac.Sum(nil))
@@ -126,13 +148,44 @@ func CreateSignature(verb string, body string, date string, uri string) string {
 // GetRequestWithAuth performs a GET on the specified URL with the Content-type
 // set as "application/json" and a correct Authorization header.
 func GetRequestWithAuth(uri string) ProcessedResponse {
-	request := createRequest("GET", uri)
-	signature := CreateSignature("GET", "", request.Header.Get("Date"), uri)
-	request.Header.Add("Authorization", "GDS username:"+signature)
+	return RequestWithAuth("GET", uri, "")
+}
+
+// RequestWithAuth performs a request of any method on the specified URL with
+// the provided body and a correct Authorization header.
+func RequestWithAuth(method string, uri string, body string) ProcessedResponse {
+	return RequestAs("username", "password", method, uri, body)
+}
+
+// RequestAs performs a request of any method on the specified URL with the
+// provided body, signed by the given user.
+func RequestAs(username string, secret string, method string, uri string, body string) ProcessedResponse {
+	request := createRequest(method, uri, body)
+	signature := CreateSignature(method, body, request.Header.Get("Date"), request.URL.Path, secret)
+	request.Header.Add("Authorization", "GDS "+username+":"+signature)
 	response := do(request)
-	body := getResponseBody(response)
+	responseBody := getResponseBody(response)
 
-	return ProcessedResponse{response.Header, response.StatusCode, body}
+	return ProcessedResponse{response.Header, response.StatusCode, responseBody}
+}
+
+// PatchWithAuth performs a PATCH on the specified URL with a body of the
+// given media type and a correct Authorization header.
+func PatchWithAuth(uri string, contentType string, body string) ProcessedResponse {
+	return PatchAs("username", "password", uri, contentType, body)
+}
+
+// PatchAs performs a PATCH on the specified URL with a body of the given
+// media type, signed by the given user.
+func PatchAs(username string, secret string, uri string, contentType string, body string) ProcessedResponse {
+	request := createRequest("PATCH", uri, body)
+	request.Header.Set("Content-Type", contentType)
+	signature := CreateSignature("PATCH", body, request.Header.Get("Date"), request.URL.Path, secret)
+	request.Header.Add("Authorization", "GDS "+username+":"+signature)
+	response := do(request)
+	responseBody := getResponseBody(response)
+
+	return ProcessedResponse{response.Header, response.StatusCode, responseBody}
 }
 
 // MainSpec is the master specification test for the REST server.
@@ -151,18 +204,49 @@ func MainSpec(c gospec.Context) {
 			c.Expect(msg.Msg, Equals, "success")
 			c.Expect(len(msg.Results), Equals, 2)
 		})
+
+		c.Specify("links to itself and to the items of the collections", func() {
+			var msg struct {
+				Links map[string]Link `json:"_links"`
+			}
+			json.Unmarshal([]byte(response.Body), &msg)
+			c.Expect(msg.Links["self"].Href, Equals, "/")
+			c.Expect(msg.Links["provider"].Href, Equals, "/v1.0/providers/{id}")
+			c.Expect(msg.Links["provider"].Templated, IsTrue)
+		})
+
+		c.Specify("advertises resources that exist", func() {
+			var msg MessageSuccess
+			json.Unmarshal([]byte(response.Body), &msg)
+			for _, r := range msg.Results {
+				c.Expect(GetRequestWithAuth(r.Uri).Code, Equals, 200)
+			}
+		})
 	})
 
 	c.Specify("GET /providers", func() {
 
 		c.Specify("returns 401 unauthorized when Authorization is not provided", func() {
-			response := GetRequest("/providers")
+			response := GetRequest("/v1.0/providers")
 			c.Expect(response.Code, Equals, 401)
 			c.Expect(response.Header.Get("WWW-Authenticate"), Not(IsNil))
 		})
 
+		c.Specify("returns 406 not acceptable when JSON is not accepted", func() {
+			request := createRequest("GET", "/v1.0/providers", "")
+			request.Header.Set("Accept", "text/html")
+			response := do(request)
+			body := getResponseBody(response)
+			var msg MessageError
+			json.Unmarshal([]byte(body), &msg)
+
+			c.Expect(response.StatusCode, Equals, 406)
+			c.Expect(response.Header.Get("Content-Type"), Equals, "application/json")
+			c.Expect(msg.Code, Equals, 406)
+		})
+
 		c.Specify("returns 401 unauthorized when Authorization does not contain two arguments", func() {
-			request := createRequest("GET", "/providers")
+			request := createRequest("GET", "/v1.0/providers", "")
 			request.Header.Add("Authorization", "invalid auth header")
 			response := do(request)
 			body := getResponseBody(response)
@@ -175,7 +259,7 @@ func MainSpec(c gospec.Context) {
 		})
 
 		c.Specify("returns 401 unauthorized when Authorization does not contain GDS", func() {
-			request := createRequest("GET", "/providers")
+			request := createRequest("GET", "/v1.0/providers", "")
 			request.Header.Add("Authorization", "INVALID onetwothreefour")
 			response := do(request)
 			body := getResponseBody(response)
@@ -188,7 +272,7 @@ func MainSpec(c gospec.Context) {
 		})
 
 		c.Specify("returns 401 unauthorized when Authorization does not have key:signature format", func() {
-			request := createRequest("GET", "/providers")
+			request := createRequest("GET", "/v1.0/providers", "")
 			request.Header.Add("Authorization", "GDS onetwothreefour")
 			response := do(request)
 			body := getResponseBody(response)
@@ -201,7 +285,7 @@ func MainSpec(c gospec.Context) {
 		})
 
 		c.Specify("returns 401 unauthorized when key is not a valid username", func() {
-			request := createRequest("GET", "/providers")
+			request := createRequest("GET", "/v1.0/providers", "")
 			request.Header.Add("Authorization", "GDS baduser:signature")
 			response := do(request)
 			body := getResponseBody(response)
@@ -214,7 +298,7 @@ func MainSpec(c gospec.Context) {
 		})
 
 		c.Specify("returns 401 unauthorized when the signature is not valid", func() {
-			request := createRequest("GET", "/providers")
+			request := createRequest("GET", "/v1.0/providers", "")
 			request.Header.Add("Authorization", "GDS username:signature")
 			response := do(request)
 			body := getResponseBody(response)
@@ -227,7 +311,7 @@ func MainSpec(c gospec.Context) {
 		})
 
 		c.Specify("returns a list of providers when valid credentials are provided", func() {
-		        response := GetRequestWithAuth("/providers")
+		        response := GetRequestWithAuth("/v1.0/providers")
 			c.Expect(response.Code, Equals, 200)
 
                         var msg MessageSuccess
@@ -237,4 +321,651 @@ func MainSpec(c gospec.Context) {
 			c.Expect(len(msg.Results), Equals, 3)
 		})
 	})
+
+	c.Specify("GET /providers with paging", func() {
+
+		c.Specify("returns the requested page with the total and links", func() {
+			var msg MessageSuccess
+			response := GetRequestWithAuth("/v1.0/providers?limit=2")
+			c.Expect(response.Code, Equals, 200)
+			json.Unmarshal([]byte(response.Body), &msg)
+			c.Expect(len(msg.Results), Equals, 2)
+			c.Expect(msg.Total, Equals, int64(3))
+			c.Expect(msg.Next, Equals, "/v1.0/providers?limit=2&offset=2")
+			c.Expect(msg.Prev, Equals, "")
+
+			var links struct {
+				Links map[string]json.RawMessage `json:"_links"`
+			}
+			json.Unmarshal([]byte(response.Body), &links)
+			var next, create Link
+			json.Unmarshal(links.Links["next"], &next)
+			json.Unmarshal(links.Links["create"], &create)
+			c.Expect(next.Href, Equals, msg.Next)
+			c.Expect(create.Href, Equals, "/v1.0/providers")
+			c.Expect(create.Method, Equals, "POST")
+
+			response = GetRequestWithAuth(msg.Next)
+			json.Unmarshal([]byte(response.Body), &msg)
+			c.Expect(len(msg.Results), Equals, 1)
+			c.Expect(msg.Results[0].Label, Equals, "National Library of Medicine")
+			c.Expect(msg.Next, Equals, "")
+			c.Expect(msg.Prev, Equals, "/v1.0/providers?limit=2&offset=0")
+		})
+
+		c.Specify("returns 400 for a malformed limit or offset", func() {
+			c.Expect(GetRequestWithAuth("/v1.0/providers?limit=many").Code, Equals, 400)
+			c.Expect(GetRequestWithAuth("/v1.0/providers?offset=-1").Code, Equals, 400)
+			c.Expect(GetRequestWithAuth("/v1.0/providers?offset="+strconv.Itoa(maxInt-5)).Code, Equals, 400)
+		})
+	})
+
+	c.Specify("GET /providers with fields or embedding", func() {
+
+		c.Specify("includes only the requested fields", func() {
+			response := GetRequestWithAuth("/v1.0/providers?fields=name,icon")
+			c.Expect(response.Code, Equals, 200)
+
+			var msg struct {
+				Results []struct {
+					Label    string                 `json:"label"`
+					Fields   map[string]interface{} `json:"fields"`
+					Embedded interface{}            `json:"embedded"`
+				} `json:"results"`
+			}
+			json.Unmarshal([]byte(response.Body), &msg)
+			c.Expect(len(msg.Results), Equals, 3)
+			c.Expect(len(msg.Results[0].Fields), Equals, 2)
+			c.Expect(msg.Results[0].Fields["name"], Equals, msg.Results[0].Label)
+			c.Expect(msg.Results[0].Embedded, IsNil)
+		})
+
+		c.Specify("embeds the complete objects", func() {
+			response := GetRequestWithAuth("/v1.0/providers?embed=full")
+			c.Expect(response.Code, Equals, 200)
+
+			var msg struct {
+				Results []struct {
+					Label    string   `json:"label"`
+					Embedded Provider `json:"embedded"`
+				} `json:"results"`
+			}
+			json.Unmarshal([]byte(response.Body), &msg)
+			c.Expect(len(msg.Results), Equals, 3)
+			c.Expect(msg.Results[0].Embedded.Name, Equals, msg.Results[0].Label)
+		})
+
+		c.Specify("leaves out objects that no longer exist", func() {
+			// an index entry left behind, as if the Provider were deleted
+			// while listing
+			db := DbConnect()
+			db.Zadd("idx:Provider", 999999, "999999")
+			db.Hset("idx:Provider:labels", "999999", "Gone")
+			defer db.Zrem("idx:Provider", "999999")
+			defer db.Hdel("idx:Provider:labels", "999999")
+
+			response := GetRequestWithAuth("/v1.0/providers?embed=full")
+			c.Expect(response.Code, Equals, 200)
+			c.Expect(strings.Contains(response.Body, "Gone"), IsFalse)
+		})
+
+		c.Specify("never shows the secret of a User", func() {
+			response := GetRequestWithAuth("/v1.0/users?embed=full")
+			c.Expect(response.Code, Equals, 200)
+			c.Expect(strings.Contains(response.Body, "password"), IsFalse)
+			c.Expect(GetRequestWithAuth("/v1.0/users?fields=secret").Code, Equals, 400)
+		})
+
+		c.Specify("returns 400 for unknown fields or embeddings", func() {
+			c.Expect(GetRequestWithAuth("/v1.0/providers?fields=name,colour").Code, Equals, 400)
+			c.Expect(GetRequestWithAuth("/v1.0/providers?embed=some").Code, Equals, 400)
+		})
+	})
+
+	c.Specify("GET /providers with sorting and filtering", func() {
+		labels := func(uri string) []string {
+			var msg MessageSuccess
+			json.Unmarshal([]byte(GetRequestWithAuth(uri).Body), &msg)
+			var labels []string
+			for _, r := range msg.Results {
+				labels = append(labels, r.Label)
+			}
+			return labels
+		}
+
+		c.Specify("sorts by a field", func() {
+			sorted := labels("/v1.0/providers?sort=name")
+			c.Expect(len(sorted), Equals, 3)
+			c.Expect(sorted[0], Equals, "FactCheck.org")
+			c.Expect(sorted[1], Equals, "National Library of Medicine")
+			c.Expect(sorted[2], Equals, "OpenLibrary.org")
+			c.Expect(labels("/v1.0/providers?sort=-name&limit=1")[0], Equals, "OpenLibrary.org")
+			c.Expect(labels("/v1.0/providers?sort=id")[0], Equals, "National Library of Medicine")
+		})
+
+		c.Specify("filters by a field, with the total of the matches", func() {
+			var msg MessageSuccess
+			response := GetRequestWithAuth("/v1.0/providers?filter=name~library&sort=name&limit=1")
+			c.Expect(response.Code, Equals, 200)
+			json.Unmarshal([]byte(response.Body), &msg)
+			c.Expect(msg.Total, Equals, int64(2))
+			c.Expect(msg.Results[0].Label, Equals, "National Library of Medicine")
+			c.Expect(msg.Next, Not(Equals), "")
+
+			c.Expect(labels(msg.Next), ContainsExactly, Values("OpenLibrary.org"))
+		})
+
+		c.Specify("returns 400 for unknown fields or malformed filters", func() {
+			c.Expect(GetRequestWithAuth("/v1.0/providers?sort=colour").Code, Equals, 400)
+			c.Expect(GetRequestWithAuth("/v1.0/providers?filter=colour~red").Code, Equals, 400)
+			c.Expect(GetRequestWithAuth("/v1.0/providers?filter=name").Code, Equals, 400)
+			c.Expect(GetRequestWithAuth("/v1.0/users?filter=secret~pass").Code, Equals, 400)
+		})
+	})
+
+	c.Specify("Reindex rebuilds the index of providers", func() {
+		err := Reindex(DbConnect())
+		c.Expect(err, IsNil)
+
+		var msg MessageSuccess
+		response := GetRequestWithAuth("/v1.0/providers")
+		json.Unmarshal([]byte(response.Body), &msg)
+		c.Expect(len(msg.Results), Equals, 3)
+		c.Expect(msg.Results[0].Label, Equals, "OpenLibrary.org")
+	})
+
+	c.Specify("XML representations", func() {
+
+		c.Specify("GET / lists the available resources", func() {
+			request := createRequest("GET", "/", "")
+			request.Header.Set("Accept", "application/xml")
+			response := do(request)
+			body := getResponseBody(response)
+
+			var msg MessageSuccess
+			err := xml.Unmarshal([]byte(body), &msg)
+			c.Expect(err, IsNil)
+			c.Expect(response.StatusCode, Equals, 200)
+			c.Expect(response.Header.Get("Content-Type"), Equals, "application/xml")
+			c.Expect(msg.Msg, Equals, "success")
+			c.Expect(len(msg.Results), Equals, 2)
+		})
+
+		c.Specify("GET /providers returns a page of Providers", func() {
+			response := GetRequestWithAuth("/v1.0/providers?format=xml&limit=2")
+			var msg MessageSuccess
+			err := xml.Unmarshal([]byte(response.Body), &msg)
+			c.Expect(err, IsNil)
+			c.Expect(response.Header.Get("Content-Type"), Equals, "application/xml")
+			c.Expect(len(msg.Results), Equals, 2)
+			c.Expect(msg.Total, Equals, int64(3))
+			c.Expect(msg.Next, Equals, "/v1.0/providers?format=xml&limit=2&offset=2")
+		})
+
+		c.Specify("GET /providers/id returns the Provider", func() {
+			response := GetRequestWithAuth("/v1.0/providers/1001?format=xml")
+			var got struct {
+				Msg    string   `xml:"msg"`
+				Result Provider `xml:"result"`
+			}
+			err := xml.Unmarshal([]byte(response.Body), &got)
+			c.Expect(err, IsNil)
+			c.Expect(got.Msg, Equals, "success")
+			c.Expect(got.Result.Name, Not(Equals), "")
+		})
+
+		c.Specify("errors are returned as XML", func() {
+			response := GetRequestWithAuth("/v1.0/providers/999999?format=xml")
+			var msg MessageError
+			err := xml.Unmarshal([]byte(response.Body), &msg)
+			c.Expect(err, IsNil)
+			c.Expect(response.Code, Equals, 404)
+			c.Expect(msg.Code, Equals, 404)
+		})
+	})
+
+	c.Specify("POST /providers", func() {
+
+		c.Specify("returns 400 when the Provider has no name", func() {
+			response := RequestWithAuth("POST", "/v1.0/providers", `{"descr":"nameless"}`)
+			c.Expect(response.Code, Equals, 400)
+		})
+
+		c.Specify("creates a Provider that can be fetched, replaced, and deleted", func() {
+			response := RequestWithAuth("POST", "/v1.0/providers", `{"name":"Project Gutenberg","icon":"http://example.com/pg.png"}`)
+			c.Expect(response.Code, Equals, 201)
+
+			uri := response.Header.Get("Location")
+			c.Expect(uri, Not(Equals), "")
+
+			response = GetRequestWithAuth(uri)
+			c.Expect(response.Code, Equals, 200)
+
+			var got struct {
+				Msg    string   `json:"msg"`
+				Result Provider `json:"result"`
+			}
+			err := json.Unmarshal([]byte(response.Body), &got)
+			c.Expect(err, Equals, nil)
+			c.Expect(got.Result.Name, Equals, "Project Gutenberg")
+			c.Expect(got.Result.Icon, Equals, "http://example.com/pg.png")
+
+			var links struct {
+				Links map[string]json.RawMessage `json:"_links"`
+			}
+			json.Unmarshal([]byte(response.Body), &links)
+			var self, collection Link
+			var edit []Link
+			json.Unmarshal(links.Links["self"], &self)
+			json.Unmarshal(links.Links["collection"], &collection)
+			json.Unmarshal(links.Links["edit"], &edit)
+			c.Expect(self.Href, Equals, uri)
+			c.Expect(collection.Href, Equals, "/v1.0/providers")
+			c.Expect(len(edit), Equals, 2)
+
+			response = RequestWithAuth("PUT", uri, `{"name":"Gutenberg","descr":"Free e-books"}`)
+			c.Expect(response.Code, Equals, 200)
+
+			// the rename replaces the index entry rather than adding one
+			var renamed MessageSuccess
+			response = GetRequestWithAuth("/v1.0/providers")
+			json.Unmarshal([]byte(response.Body), &renamed)
+			c.Expect(len(renamed.Results), Equals, 4)
+			c.Expect(renamed.Results[0].Label, Equals, "Gutenberg")
+			c.Expect(renamed.Results[0].Uri, Equals, uri)
+
+			response = GetRequestWithAuth(uri)
+			json.Unmarshal([]byte(response.Body), &got)
+			c.Expect(got.Result.Name, Equals, "Gutenberg")
+			c.Expect(got.Result.Icon, Equals, "")
+			c.Expect(got.Result.Description, Equals, "Free e-books")
+
+			response = RequestWithAuth("DELETE", uri, "")
+			c.Expect(response.Code, Equals, 204)
+
+			response = GetRequestWithAuth(uri)
+			c.Expect(response.Code, Equals, 404)
+
+			var list MessageSuccess
+			response = GetRequestWithAuth("/v1.0/providers")
+			json.Unmarshal([]byte(response.Body), &list)
+			c.Expect(len(list.Results), Equals, 3)
+		})
+	})
+
+	c.Specify("PATCH /providers/id", func() {
+
+		c.Specify("patches a Provider", func() {
+			response := RequestWithAuth("POST", "/v1.0/providers", `{"name":"Project Gutenberg","icon":"http://example.com/pg.png"}`)
+			c.Expect(response.Code, Equals, 201)
+			uri := response.Header.Get("Location")
+
+			response = PatchWithAuth(uri, "application/merge-patch+json", `{"descr":"Free e-books","icon":null}`)
+			c.Expect(response.Code, Equals, 200)
+
+			var got struct {
+				Msg    string   `json:"msg"`
+				Result Provider `json:"result"`
+			}
+			response = GetRequestWithAuth(uri)
+			json.Unmarshal([]byte(response.Body), &got)
+			c.Expect(got.Result.Name, Equals, "Project Gutenberg")
+			c.Expect(got.Result.Icon, Equals, "")
+			c.Expect(got.Result.Description, Equals, "Free e-books")
+
+			response = PatchWithAuth(uri, "application/json-patch+json", `[{"op":"test","path":"/name","value":"Project Gutenberg"},{"op":"replace","path":"/name","value":"Gutenberg"}]`)
+			c.Expect(response.Code, Equals, 200)
+			response = GetRequestWithAuth(uri)
+			json.Unmarshal([]byte(response.Body), &got)
+			c.Expect(got.Result.Name, Equals, "Gutenberg")
+			c.Expect(got.Result.Description, Equals, "Free e-books")
+
+			// invalid operations and media types are rejected
+			response = PatchWithAuth(uri, "application/json-patch+json", `[{"op":"remove","path":"/publisher"}]`)
+			c.Expect(response.Code, Equals, 422)
+			response = PatchWithAuth(uri, "application/json-patch+json", `[{"op":"remove","path":"/name"}]`)
+			c.Expect(response.Code, Equals, 422)
+			response = PatchWithAuth(uri, "application/json", `{"name":"PG"}`)
+			c.Expect(response.Code, Equals, 415)
+			c.Expect(response.Header.Get("Accept-Patch"), Equals, "application/merge-patch+json, application/json-patch+json")
+			response = PatchWithAuth(uri, "application/merge-patch+json", `{"name":`)
+			c.Expect(response.Code, Equals, 400)
+
+			// as are members a Provider does not have
+			response = PatchWithAuth(uri, "application/merge-patch+json", `{"homepage":"http://www.gutenberg.org/"}`)
+			c.Expect(response.Code, Equals, 422)
+			response = PatchWithAuth(uri, "application/json-patch+json", `[{"op":"add","path":"/homepage","value":"http://www.gutenberg.org/"}]`)
+			c.Expect(response.Code, Equals, 422)
+
+			c.Expect(RequestWithAuth("DELETE", uri, "").Code, Equals, 204)
+		})
+	})
+
+	c.Specify("GET /search", func() {
+		search := func(q string) MessageSuccess {
+			var msg MessageSuccess
+			response := GetRequestWithAuth("/v1.0/search?q=" + url.QueryEscape(q))
+			c.Expect(response.Code, Equals, 200)
+			json.Unmarshal([]byte(response.Body), &msg)
+			return msg
+		}
+
+		c.Specify("returns 400 without words to search for", func() {
+			c.Expect(GetRequestWithAuth("/v1.0/search").Code, Equals, 400)
+			c.Expect(GetRequestWithAuth("/v1.0/search?q=%20").Code, Equals, 400)
+		})
+
+		c.Specify("finds providers by the stems of their words, best first", func() {
+			msg := search("libraries")
+			c.Expect(msg.Total, Equals, int64(1))
+			c.Expect(msg.Results[0].Label, Equals, "National Library of Medicine")
+			c.Expect(msg.Results[0].Snippet, Equals, "National <em>Library</em> of Medicine")
+			c.Expect(msg.Results[0].Score > 0, IsTrue)
+
+			c.Expect(search("the of").Total, Equals, int64(0))
+		})
+
+		c.Specify("follows providers as they are saved and deleted", func() {
+			response := RequestWithAuth("POST", "/v1.0/providers", `{"name":"Project Gutenberg","descr":"Free ebooks of public domain works"}`)
+			c.Expect(response.Code, Equals, 201)
+			uri := response.Header.Get("Location")
+
+			msg := search("free ebook")
+			c.Expect(msg.Total, Equals, int64(1))
+			c.Expect(msg.Results[0].Uri, Equals, uri)
+			c.Expect(msg.Results[0].Snippet, Equals, "<em>Free</em> <em>ebooks</em> of public domain works")
+
+			response = RequestWithAuth("PUT", uri, `{"name":"Project Gutenberg","descr":"Classic literature"}`)
+			c.Expect(response.Code, Equals, 200)
+			c.Expect(search("ebooks").Total, Equals, int64(0))
+			c.Expect(search("literature").Total, Equals, int64(1))
+
+			c.Expect(RequestWithAuth("DELETE", uri, "").Code, Equals, 204)
+			c.Expect(search("literature").Total, Equals, int64(0))
+		})
+
+		c.Specify("leaves out objects that no longer exist", func() {
+			// an entry left behind, as if the object were deleted while
+			// searching
+			db := DbConnect()
+			term := searchTermKey(Terms("zanzibar")[0])
+			db.Hset(term, "prov:999999", "1")
+			defer db.Del(term)
+
+			msg := search("zanzibar")
+			c.Expect(len(msg.Results), Equals, 0)
+		})
+	})
+
+	c.Specify("SaveHashes keeps the hash, the index, and the search entries together", func() {
+		response := RequestWithAuth("POST", "/v1.0/providers", `{"name":"Project Gutenberg"}`)
+		c.Expect(response.Code, Equals, 201)
+		uri := response.Header.Get("Location")
+
+		// rename the Provider from several requests at once
+		names := []string{"Alpha", "Bravo", "Charlie", "Delta", "Echo", "Foxtrot"}
+		codes := make(chan int)
+		for _, name := range names {
+			go func(name string) {
+				codes <- RequestWithAuth("PUT", uri, `{"name":"`+name+`"}`).Code
+			}(name)
+		}
+		for _ = range names {
+			c.Expect(<-codes, Equals, 200)
+		}
+
+		// whichever rename came last, all three must agree on it
+		var got struct {
+			Msg    string   `json:"msg"`
+			Result Provider `json:"result"`
+		}
+		json.Unmarshal([]byte(GetRequestWithAuth(uri).Body), &got)
+		name := got.Result.Name
+		c.Expect(containsString(names, name), IsTrue)
+
+		var list MessageSuccess
+		json.Unmarshal([]byte(GetRequestWithAuth("/v1.0/providers").Body), &list)
+		c.Expect(list.Total, Equals, int64(4))
+		c.Expect(list.Results[0].Uri, Equals, uri)
+		c.Expect(list.Results[0].Label, Equals, name)
+
+		for _, n := range names {
+			var found MessageSuccess
+			json.Unmarshal([]byte(GetRequestWithAuth("/v1.0/search?q="+n).Body), &found)
+			if n == name {
+				c.Expect(found.Total, Equals, int64(1))
+			} else {
+				c.Expect(found.Total, Equals, int64(0))
+			}
+		}
+
+		c.Expect(RequestWithAuth("DELETE", uri, "").Code, Equals, 204)
+	})
+
+	c.Specify("POST /users", func() {
+
+		c.Specify("returns 400 when the username is invalid", func() {
+			response := RequestWithAuth("POST", "/v1.0/users", `{"username":"bad name","secret":"s3cret"}`)
+			c.Expect(response.Code, Equals, 400)
+		})
+
+		c.Specify("returns 409 when the username is taken", func() {
+			response := RequestWithAuth("POST", "/v1.0/users", `{"username":"username","secret":"s3cret"}`)
+			c.Expect(response.Code, Equals, 409)
+		})
+
+		c.Specify("registers a User who can authenticate with their own secret", func() {
+			request := createRequest("POST", "/v1.0/users", `{"username":"jsmith","name":"John Smith","email":"js@example.com","secret":"s3cret"}`)
+			response := do(request)
+			body := getResponseBody(response)
+			c.Expect(response.StatusCode, Equals, 201)
+			c.Expect(strings.Contains(body, "s3cret"), IsFalse)
+
+			uri := response.Header.Get("Location")
+
+			// another user may read but not modify the profile
+			c.Expect(GetRequestWithAuth(uri).Code, Equals, 200)
+			c.Expect(RequestWithAuth("DELETE", uri, "").Code, Equals, 403)
+
+			// the wrong secret is rejected
+			c.Expect(RequestAs("jsmith", "password", "GET", uri, "").Code, Equals, 401)
+
+			// the user may update themselves
+			processed := RequestAs("jsmith", "s3cret", "PUT", uri, `{"username":"jsmith","name":"Johnny Smith"}`)
+			c.Expect(processed.Code, Equals, 200)
+
+			var got struct {
+				Msg    string `json:"msg"`
+				Result User   `json:"result"`
+			}
+			processed = RequestAs("jsmith", "s3cret", "GET", uri, "")
+			json.Unmarshal([]byte(processed.Body), &got)
+			c.Expect(got.Result.DisplayName, Equals, "Johnny Smith")
+			c.Expect(got.Result.Secret, Equals, "")
+
+			// and delete themselves, releasing the username and taking
+			// their texts and resources with them
+			processed = RequestAs("jsmith", "s3cret", "POST", uri+"/texts", `{"title":"Diary","body":"Dear diary"}`)
+			c.Expect(processed.Code, Equals, 201)
+			text := processed.Header.Get("Location")
+			processed = RequestAs("jsmith", "s3cret", "POST", uri+"/resources", `{"title":"Meditations","provider":"1003"}`)
+			c.Expect(processed.Code, Equals, 201)
+			resource := processed.Header.Get("Location")
+
+			c.Expect(RequestAs("jsmith", "s3cret", "DELETE", uri, "").Code, Equals, 204)
+			c.Expect(GetRequestWithAuth(uri).Code, Equals, 404)
+			c.Expect(RequestAs("jsmith", "s3cret", "GET", "/v1.0/users", "").Code, Equals, 401)
+			c.Expect(GetRequestWithAuth(text).Code, Equals, 404)
+			c.Expect(GetRequestWithAuth(resource).Code, Equals, 404)
+
+			var found MessageSuccess
+			processed = GetRequestWithAuth("/v1.0/search?q=diary+meditations")
+			json.Unmarshal([]byte(processed.Body), &found)
+			c.Expect(found.Total, Equals, int64(0))
+		})
+
+		c.Specify("renames a User, releasing the old username", func() {
+			response := do(createRequest("POST", "/v1.0/users", `{"username":"jdoe","secret":"s3cret"}`))
+			c.Expect(response.StatusCode, Equals, 201)
+			uri := response.Header.Get("Location")
+
+			// a taken username leaves the User as it was
+			processed := RequestAs("jdoe", "s3cret", "PUT", uri, `{"username":"username"}`)
+			c.Expect(processed.Code, Equals, 409)
+			c.Expect(RequestAs("jdoe", "s3cret", "GET", uri, "").Code, Equals, 200)
+			c.Expect(GetRequestWithAuth(uri).Code, Equals, 200)
+
+			processed = PatchAs("jdoe", "s3cret", uri, "application/merge-patch+json", `{"username":"janedoe"}`)
+			c.Expect(processed.Code, Equals, 200)
+
+			c.Expect(RequestAs("jdoe", "s3cret", "GET", uri, "").Code, Equals, 401)
+			c.Expect(RequestAs("janedoe", "s3cret", "GET", uri, "").Code, Equals, 200)
+
+			// so that someone else may register it
+			response = do(createRequest("POST", "/v1.0/users", `{"username":"jdoe","secret":"other"}`))
+			c.Expect(response.StatusCode, Equals, 201)
+			other := response.Header.Get("Location")
+
+			c.Expect(RequestAs("jdoe", "other", "DELETE", other, "").Code, Equals, 204)
+			c.Expect(RequestAs("janedoe", "s3cret", "DELETE", uri, "").Code, Equals, 204)
+		})
+	})
+
+	c.Specify("/users/id/texts", func() {
+		// the fixture user is the first created after nxUserId was seeded
+		texts := "/v1.0/users/1001/texts"
+
+		c.Specify("returns 404 for a User that does not exist", func() {
+			c.Expect(GetRequestWithAuth("/v1.0/users/999999/texts").Code, Equals, 404)
+		})
+
+		c.Specify("returns 403 when writing another User's texts", func() {
+			response := RequestWithAuth("POST", "/v1.0/users/999999/texts", `{"title":"Not mine"}`)
+			c.Expect(response.Code, Equals, 403)
+		})
+
+		c.Specify("creates, lists, replaces, and deletes a Text", func() {
+			response := RequestWithAuth("POST", texts, `{"title":"Notes","body":"First draft"}`)
+			c.Expect(response.Code, Equals, 201)
+			uri := response.Header.Get("Location")
+
+			var list MessageSuccess
+			response = GetRequestWithAuth(texts)
+			json.Unmarshal([]byte(response.Body), &list)
+			c.Expect(len(list.Results), Equals, 1)
+			c.Expect(list.Results[0].Label, Equals, "Notes")
+			c.Expect(list.Results[0].Uri, Equals, uri)
+
+			response = RequestWithAuth("PUT", uri, `{"title":"Final notes","body":"Second draft"}`)
+			c.Expect(response.Code, Equals, 200)
+
+			var got struct {
+				Msg    string `json:"msg"`
+				Result Text   `json:"result"`
+			}
+			response = GetRequestWithAuth(uri)
+			json.Unmarshal([]byte(response.Body), &got)
+			c.Expect(got.Result.Title, Equals, "Final notes")
+			c.Expect(got.Result.Body, Equals, "Second draft")
+			c.Expect(got.Result.Owner, Equals, "1001")
+			c.Expect(got.Result.Created.IsZero(), IsFalse)
+
+			c.Expect(RequestWithAuth("DELETE", uri, "").Code, Equals, 204)
+			c.Expect(GetRequestWithAuth(uri).Code, Equals, 404)
+		})
+	})
+
+	c.Specify("/users/id/resources", func() {
+		// the fixture user and providers are the first created after the
+		// counters were seeded
+		resources := "/v1.0/users/1001/resources"
+
+		c.Specify("returns 400 when the referenced Provider does not exist", func() {
+			response := RequestWithAuth("POST", resources, `{"title":"Orphan","provider":"999999"}`)
+			c.Expect(response.Code, Equals, 400)
+		})
+
+		c.Specify("returns 400 when the url is not absolute", func() {
+			response := RequestWithAuth("POST", resources, `{"title":"Relative","url":"books/1","provider":"1001"}`)
+			c.Expect(response.Code, Equals, 400)
+		})
+
+		c.Specify("keeps the referenced Provider from being deleted", func() {
+			response := RequestWithAuth("POST", "/v1.0/providers", `{"name":"Project Gutenberg"}`)
+			c.Expect(response.Code, Equals, 201)
+			provider := response.Header.Get("Location")
+			id := provider[strings.LastIndex(provider, "/")+1:]
+
+			response = RequestWithAuth("POST", resources, `{"title":"Walden","provider":"`+id+`"}`)
+			c.Expect(response.Code, Equals, 201)
+			uri := response.Header.Get("Location")
+
+			c.Expect(RequestWithAuth("DELETE", provider, "").Code, Equals, 409)
+			c.Expect(GetRequestWithAuth(provider).Code, Equals, 200)
+
+			// though it may still be replaced
+			c.Expect(RequestWithAuth("PUT", provider, `{"name":"Gutenberg"}`).Code, Equals, 200)
+			c.Expect(RequestWithAuth("DELETE", provider, "").Code, Equals, 409)
+
+			c.Expect(RequestWithAuth("DELETE", uri, "").Code, Equals, 204)
+			c.Expect(RequestWithAuth("DELETE", provider, "").Code, Equals, 204)
+		})
+
+		c.Specify("patches a Resource", func() {
+			response := RequestWithAuth("POST", resources, `{"title":"SICP","authors":["Harold Abelson"],"url":"http://mitpress.mit.edu/sicp/","provider":"1003"}`)
+			c.Expect(response.Code, Equals, 201)
+			uri := response.Header.Get("Location")
+
+			response = PatchWithAuth(uri, "application/merge-patch+json", `{"url":null}`)
+			c.Expect(response.Code, Equals, 200)
+			response = PatchWithAuth(uri, "application/json-patch+json", `[{"op":"add","path":"/authors/-","value":"Gerald Jay Sussman"}]`)
+			c.Expect(response.Code, Equals, 200)
+
+			var got struct {
+				Msg    string   `json:"msg"`
+				Result Citation `json:"result"`
+			}
+			response = GetRequestWithAuth(uri)
+			json.Unmarshal([]byte(response.Body), &got)
+			c.Expect(got.Result.Title, Equals, "SICP")
+			c.Expect(got.Result.Url, Equals, "")
+			c.Expect(got.Result.Authors, ContainsExactly, Values("Harold Abelson", "Gerald Jay Sussman"))
+
+			// the patched Resource must still be valid
+			response = PatchWithAuth(uri, "application/merge-patch+json", `{"provider":"9999"}`)
+			c.Expect(response.Code, Equals, 422)
+
+			c.Expect(RequestWithAuth("DELETE", uri, "").Code, Equals, 204)
+		})
+
+		c.Specify("creates, replaces, and deletes a Resource", func() {
+			response := RequestWithAuth("POST", resources, `{"title":"SICP","authors":["Harold Abelson","Gerald Jay Sussman"],"url":"http://mitpress.mit.edu/sicp/","identifiers":["isbn:0262510871"],"provider":"1003"}`)
+			c.Expect(response.Code, Equals, 201)
+			uri := response.Header.Get("Location")
+
+			var got struct {
+				Msg    string   `json:"msg"`
+				Result Citation `json:"result"`
+			}
+			response = GetRequestWithAuth(uri)
+			json.Unmarshal([]byte(response.Body), &got)
+			c.Expect(got.Result.Title, Equals, "SICP")
+			c.Expect(len(got.Result.Authors), Equals, 2)
+			c.Expect(got.Result.Authors[1], Equals, "Gerald Jay Sussman")
+			c.Expect(got.Result.Identifiers[0], Equals, "isbn:0262510871")
+			c.Expect(got.Result.Provider, Equals, "1003")
+
+			response = RequestWithAuth("PUT", uri, `{"title":"SICP, 2nd ed.","authors":["Harold Abelson"],"provider":"1003"}`)
+			c.Expect(response.Code, Equals, 200)
+
+			response = GetRequestWithAuth(uri)
+			json.Unmarshal([]byte(response.Body), &got)
+			c.Expect(got.Result.Title, Equals, "SICP, 2nd ed.")
+			c.Expect(len(got.Result.Authors), Equals, 1)
+			c.Expect(got.Result.Url, Equals, "")
+
+			c.Expect(RequestWithAuth("DELETE", uri, "").Code, Equals, 204)
+			c.Expect(GetRequestWithAuth(uri).Code, Equals, 404)
+		})
+	})
 }